@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/expgo/ag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runFmt runs ag fmt, which formats the annotations of the go files of the
+// given paths. A path is a file, a directory, or a directory followed by /...
+// to walk its sub directories too.
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	list := flags.Bool("l", false, "List the files whose annotations are not formatted, without writing them.")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stdout, "Usage of %s fmt [flags] [path ...]:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	exitCode := 0
+	for _, path := range paths {
+		files, err := goFiles(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 1
+			continue
+		}
+
+		for _, file := range files {
+			if err = fmtFile(file, *list); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exitCode = 1
+			}
+		}
+	}
+
+	return exitCode
+}
+
+func fmtFile(filename string, list bool) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	formatted, err := ag.FormatFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(content, formatted) {
+		return nil
+	}
+
+	if list {
+		fmt.Println(filename)
+		return nil
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, formatted, info.Mode().Perm())
+}
+
+// goFiles returns the go files of path.
+func goFiles(path string) ([]string, error) {
+	recursive := strings.HasSuffix(path, "/...")
+	if recursive {
+		path = strings.TrimSuffix(path, "/...")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != path && (!recursive || strings.HasPrefix(d.Name(), ".") || d.Name() == "vendor" || d.Name() == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(p, ".go") {
+			files = append(files, p)
+		}
+		return nil
+	})
+
+	return files, err
+}
@@ -22,9 +22,16 @@ func (p *Plugins) Set(value string) error {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		os.Exit(runFmt(os.Args[2:]))
+	}
+
 	var filename string
 	var fileSuffix string
 	var packageMode bool
+	var keepGoing bool
+	var buildConstraint string
+	var warningsAsErrors bool
 	var rebuild bool
 	var plugins Plugins
 	var devPlugin string
@@ -32,6 +39,9 @@ func main() {
 	flag.StringVar(&filename, "file", "", "The file is used to generate the annotation file.")
 	flag.StringVar(&fileSuffix, "file-suffix", "_ag", "Changes the default filename suffix of _ag to something else.")
 	flag.BoolVar(&packageMode, "package-mode", false, "If true, ag will work on package mode.")
+	flag.BoolVar(&keepGoing, "keep-going", false, "If true, ag will generate for the valid annotations even if some annotations are malformed.")
+	flag.StringVar(&buildConstraint, "build-constraint", "", "A build constraint expression, like linux && !race, added to the one copied from the file to the generated files.")
+	flag.BoolVar(&warningsAsErrors, "Werror", false, "If true, the warnings of the generators fail the generation like errors.")
 	flag.BoolVar(&rebuild, "rebuild", false, "If plugin is used and rebuild is set to true, the plugin program will be rebuild.")
 	flag.Var(&plugins, "plugin", "Add extended plugins to the Annotation Generator.")
 	flag.StringVar(&devPlugin, "dev-plugin", "", "Used when develop ag plugin.")
@@ -50,12 +60,15 @@ func main() {
 
 	if len(plugins) > 0 || len(devPlugin) > 0 {
 		pp := &PluginProgram{
-			Plugins:     plugins,
-			devPlugin:   devPlugin,
-			rebuild:     rebuild,
-			filename:    filename,
-			fileSuffix:  fileSuffix,
-			packageMode: packageMode,
+			Plugins:          plugins,
+			devPlugin:        devPlugin,
+			rebuild:          rebuild,
+			filename:         filename,
+			fileSuffix:       fileSuffix,
+			packageMode:      packageMode,
+			keepGoing:        keepGoing,
+			buildConstraint:  buildConstraint,
+			warningsAsErrors: warningsAsErrors,
 		}
 
 		if len(devPlugin) > 0 {
@@ -68,6 +81,15 @@ func main() {
 
 		pp.run()
 	} else {
-		generator.GenerateFile(filename, fileSuffix, packageMode)
+		err := generator.GenerateWithOptions(filename, &generator.Options{
+			OutputSuffix:     fileSuffix,
+			PackageMode:      packageMode,
+			KeepGoing:        keepGoing,
+			BuildConstraint:  buildConstraint,
+			WarningsAsErrors: warningsAsErrors,
+		})
+		if err != nil {
+			os.Exit(1)
+		}
 	}
 }
@@ -39,9 +39,12 @@ type PluginProgram struct {
 	rebuild   bool
 	devMode   bool
 	// -------------
-	filename    string
-	fileSuffix  string
-	packageMode bool
+	filename         string
+	fileSuffix       string
+	packageMode      bool
+	keepGoing        bool
+	buildConstraint  string
+	warningsAsErrors bool
 }
 
 func getPathHash(plugins []string) string {
@@ -126,7 +129,10 @@ func (pp *PluginProgram) writeReplace() {
 		panic(err)
 	}
 
-	pp.runCommand(pp.baseDir, "bash", "-c", fmt.Sprintf(`echo "replace %s => %s" >> %s`, fi.ModuleName, fi.ModuleAbsLocalPath, AGFileGoMod.Val()))
+	// the plugins may come from any module of the workspace
+	for _, modulePath := range fi.ModulePaths() {
+		pp.runCommand(pp.baseDir, "bash", "-c", fmt.Sprintf(`echo "replace %s => %s" >> %s`, modulePath, fi.Modules[modulePath], AGFileGoMod.Val()))
+	}
 }
 
 func (pp *PluginProgram) run() {
@@ -150,7 +156,7 @@ func (pp *PluginProgram) run() {
 		panic(err)
 	}
 	println("run ag plugin program, workDir: ", workDir)
-	pp.runCommand(workDir, agExe, "-file="+pp.filename, "-suffix="+pp.fileSuffix, "-package-mode="+structure.MustConvertTo[string](pp.packageMode))
+	pp.runCommand(workDir, agExe, "-file="+pp.filename, "-suffix="+pp.fileSuffix, "-package-mode="+structure.MustConvertTo[string](pp.packageMode), "-keep-going="+structure.MustConvertTo[string](pp.keepGoing), "-build-constraint="+pp.buildConstraint, "-Werror="+structure.MustConvertTo[string](pp.warningsAsErrors))
 }
 
 func (pp *PluginProgram) runCommand(workDir string, name string, arg ...string) {
@@ -165,6 +171,8 @@ func (pp *PluginProgram) runCommand(workDir string, name string, arg ...string)
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			println(string(exitErr.Stderr))
+			// a failed generation fails ag too
+			os.Exit(exitErr.ExitCode())
 		} else {
 			println(err.Error())
 		}
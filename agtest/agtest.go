@@ -0,0 +1,297 @@
+/*
+Package agtest tests GeneratorFactory plugins through the real generation,
+against golden files:
+
+	func TestColor(t *testing.T) {
+		result := agtest.Run(t, &agtest.Case{
+			Files: map[string]string{
+				"color.go": "package color\n\n//go:generate ag\n\n// @Enum {red, green}\ntype Color int\n",
+			},
+			Factories: []api.GeneratorFactory{&enum.Factory{}},
+		})
+		result.AssertNoError(t)
+		result.AssertGolden(t, "testdata/color")
+	}
+
+The sources are written to a temporary module, with a go.mod of its own, so
+neither a testdata dir nor a go.mod is needed. The golden files are named like
+the generated ones with a .golden suffix, like color_ag.go.golden, and are
+written by running the tests with -agtest.update, a flag of its own not to
+clash with the -update flag of the tests.
+*/
+package agtest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/expgo/ag/api"
+	"github.com/expgo/ag/generator"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// GoldenSuffix is the suffix of the golden files.
+const GoldenSuffix = ".golden"
+
+var update = flag.Bool("agtest.update", false, "update the golden files of agtest")
+
+// Case is a generation to test.
+type Case struct {
+	// Module is the module path of the temporary module, example.com/agtest
+	// by default.
+	Module string
+	// Files are the sources by slash separated path, in the module root.
+	Files map[string]string
+	// Dir is a directory to read the sources from, along with Files. Its
+	// golden files are left out.
+	Dir string
+	// File is the source to generate for, the first go file of Files or Dir
+	// with a go:generate comment by default.
+	File string
+	// Options of the generation. Factories is the usual one to set.
+	Options generator.Options
+	// Factories are the factories to generate with, instead of
+	// Options.Factories.
+	Factories []api.GeneratorFactory
+}
+
+// Result is the result of the generation of a Case.
+type Result struct {
+	// Dir is the temporary module.
+	Dir string
+	// Files are the files written by the generation by slash separated path
+	// relative to Dir.
+	Files map[string][]byte
+	// Diagnostics are the diagnostics of the generators.
+	Diagnostics []*api.Diagnostic
+	// Err is the error of the generation.
+	Err error
+}
+
+// Run generates c in a temporary module.
+func Run(t testing.TB, c *Case) *Result {
+	t.Helper()
+
+	dir := t.TempDir()
+	sources, err := c.sources()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := c.Module
+	if len(module) == 0 {
+		module = "example.com/agtest"
+	}
+	if _, ok := sources["go.mod"]; !ok {
+		sources["go.mod"] = []byte("module " + module + "\n\ngo 1.20\n")
+	}
+
+	file := c.File
+	for _, name := range sortedKeys(sources) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(path, sources[name], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if len(file) == 0 && strings.HasSuffix(name, ".go") && bytes.Contains(sources[name], []byte("//go:generate")) {
+			file = name
+		}
+	}
+	if len(file) == 0 {
+		t.Fatal("agtest: no file to generate for")
+	}
+
+	options := c.Options
+	if len(c.Factories) > 0 {
+		options.Factories = c.Factories
+	}
+	diagnostics := &api.Diagnostics{}
+	options.Diagnostics = diagnostics
+	if len(options.OutputSuffix) == 0 {
+		options.OutputSuffix = "_ag"
+	}
+
+	result := &Result{Dir: dir, Files: map[string][]byte{}}
+	result.Err = generator.GenerateWithOptions(filepath.Join(dir, filepath.FromSlash(file)), &options)
+	result.Diagnostics = diagnostics.List()
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if source, ok := sources[rel]; !ok || !bytes.Equal(source, content) {
+			result.Files[rel] = content
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+// sources returns the sources of c by slash separated path.
+func (c *Case) sources() (map[string][]byte, error) {
+	result := map[string][]byte{}
+
+	if len(c.Dir) > 0 {
+		err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || strings.HasSuffix(path, GoldenSuffix) {
+				return err
+			}
+			rel, err := filepath.Rel(c.Dir, path)
+			if err != nil {
+				return err
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			result[filepath.ToSlash(rel)] = content
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, content := range c.Files {
+		result[name] = []byte(content)
+	}
+
+	return result, nil
+}
+
+// AssertNoError fails t if the generation failed.
+func (r *Result) AssertNoError(t testing.TB) {
+	t.Helper()
+
+	if r.Err != nil {
+		t.Errorf("agtest: generation failed: %s", r.Err)
+	}
+}
+
+// AssertGolden compares the files written by the generation with the golden
+// files of dir, or writes them with -agtest.update. A golden file which is
+// not generated anymore fails the test too, or is removed with -agtest.update.
+func (r *Result) AssertGolden(t testing.TB, dir string) {
+	t.Helper()
+
+	golden := map[string]bool{}
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, GoldenSuffix) {
+			rel, _ := filepath.Rel(dir, path)
+			golden[filepath.ToSlash(strings.TrimSuffix(rel, GoldenSuffix))] = true
+		}
+		return nil
+	})
+
+	for _, name := range sortedKeys(r.Files) {
+		path := filepath.Join(dir, filepath.FromSlash(name)+GoldenSuffix)
+		delete(golden, name)
+
+		if *update {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, r.Files[name], 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		expected, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("agtest: %s is generated but has no golden file %s, run with -agtest.update to write it", name, path)
+			continue
+		}
+		if !bytes.Equal(expected, r.Files[name]) {
+			t.Errorf("agtest: %s differs from %s, run with -agtest.update to update it\n%s", name, path, diff(string(expected), string(r.Files[name])))
+		}
+	}
+
+	for _, name := range sortedKeys(golden) {
+		path := filepath.Join(dir, filepath.FromSlash(name)+GoldenSuffix)
+		if *update {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		t.Errorf("agtest: %s is not generated, run with -agtest.update to remove its golden file %s", name, path)
+	}
+}
+
+// DiagnosticStrings returns the diagnostics as file:line:col: severity:
+// message strings, with the file relative to the module.
+func (r *Result) DiagnosticStrings() []string {
+	result := make([]string, 0, len(r.Diagnostics))
+	for _, d := range r.Diagnostics {
+		relative := *d
+		if rel, err := filepath.Rel(r.Dir, d.Pos.Filename); err == nil && len(d.Pos.Filename) > 0 {
+			relative.Pos.Filename = filepath.ToSlash(rel)
+		}
+		result = append(result, relative.String())
+	}
+	sort.Strings(result)
+	return result
+}
+
+// AssertDiagnostics fails t unless the diagnostics, as DiagnosticStrings, are
+// the expected ones, in any order.
+func (r *Result) AssertDiagnostics(t testing.TB, expected ...string) {
+	t.Helper()
+
+	sorted := append([]string{}, expected...)
+	sort.Strings(sorted)
+
+	actual := r.DiagnosticStrings()
+	if strings.Join(sorted, "\n") != strings.Join(actual, "\n") {
+		t.Errorf("agtest: diagnostics differ\nexpected:\n\t%s\nactual:\n\t%s", strings.Join(sorted, "\n\t"), strings.Join(actual, "\n\t"))
+	}
+}
+
+// diff returns the first line where expected and actual differ.
+func diff(expected string, actual string) string {
+	e := strings.Split(expected, "\n")
+	a := strings.Split(actual, "\n")
+	for i := 0; i < len(e) || i < len(a); i++ {
+		var el, al string
+		if i < len(e) {
+			el = e[i]
+		}
+		if i < len(a) {
+			al = a[i]
+		}
+		if el != al {
+			return fmt.Sprintf("line %d:\n\texpected: %q\n\tactual:   %q", i+1, el, al)
+		}
+	}
+	return ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
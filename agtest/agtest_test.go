@@ -0,0 +1,113 @@
+package agtest
+
+import (
+	"flag"
+	"github.com/expgo/ag/api"
+	"github.com/stretchr/testify/assert"
+	"go/ast"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the usual flag of golden file tests, which agtest must not clash with
+var _ = flag.Bool("update", false, "update the golden files")
+
+type testGenerator struct {
+	tas []*api.TypedAnnotation
+}
+
+func (g *testGenerator) GetImports() []string             { return nil }
+func (g *testGenerator) WriteConst(wr io.Writer) error    { return nil }
+func (g *testGenerator) WriteInitFunc(wr io.Writer) error { return nil }
+func (g *testGenerator) WriteBody(wr io.Writer) error     { return nil }
+
+func (g *testGenerator) Decls() ([]ast.Decl, error) {
+	code := &api.Code{}
+	for _, ta := range g.tas {
+		name := ta.Node.(*ast.TypeSpec).Name.Name
+		code.Method(api.Field{Name: "x", Type: name}, "Name", nil, []api.Field{{Type: "string"}}, "return \""+name+"\"")
+	}
+	return code.Decls()
+}
+
+type testFactory struct{}
+
+func (f *testFactory) Annotations() map[string][]api.AnnotationType {
+	return map[string][]api.AnnotationType{"Named": {api.AnnotationTypeType}}
+}
+
+func (f *testFactory) New(tas []*api.TypedAnnotation) (api.Generator, error) {
+	return &testGenerator{tas: tas}, nil
+}
+
+func (f *testFactory) NewWithContext(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+	for _, ta := range tas {
+		if len(ta.Annotations.Annotations[0].Params) > 0 {
+			ctx.Diagnostics.Warnf(ta.Position(), "@Named takes no param")
+		}
+	}
+	return f.New(tas)
+}
+
+func TestRunFiles(t *testing.T) {
+	result := Run(t, &Case{
+		Files: map[string]string{
+			"color.go": "package color\n\n//go:generate ag\n\n// @Named\ntype Color int\n\n// @Named(x=1)\ntype Shape int\n",
+		},
+		Factories: []api.GeneratorFactory{&testFactory{}},
+	})
+
+	result.AssertNoError(t)
+	result.AssertGolden(t, "testdata/files")
+	result.AssertDiagnostics(t, "color.go:8:5: warning: @Named takes no param")
+	assert.Contains(t, result.Files, "color_ag.go")
+	assert.NotContains(t, result.Files, "color.go")
+}
+
+func TestRunDir(t *testing.T) {
+	result := Run(t, &Case{
+		Module:    "example.com/shapes",
+		Dir:       "testdata/dir",
+		Factories: []api.GeneratorFactory{&testFactory{}},
+	})
+
+	result.AssertNoError(t)
+	result.AssertGolden(t, "testdata/dir")
+	result.AssertDiagnostics(t)
+}
+
+// recorder records the failure of an assertion instead of failing the test.
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Helper()                           {}
+func (r *recorder) Errorf(format string, args ...any) { r.failed = true }
+func (r *recorder) Fatal(args ...any)                 { r.failed = true }
+
+func TestAssertGolden(t *testing.T) {
+	defer func(u bool) { *update = u }(*update)
+	*update = false
+
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a_ag.go"+GoldenSuffix), []byte("package a\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b_ag.go"+GoldenSuffix), []byte("package a\n"), 0o644))
+
+	r := &recorder{TB: t}
+	result := &Result{Files: map[string][]byte{"a_ag.go": []byte("package a\n")}}
+	result.AssertGolden(r, dir)
+	assert.True(t, r.failed, "a stale golden file fails")
+
+	r = &recorder{TB: t}
+	result.Files["b_ag.go"] = []byte("package b\n")
+	result.AssertGolden(r, dir)
+	assert.True(t, r.failed, "a different file fails")
+
+	r = &recorder{TB: t}
+	result.Files["b_ag.go"] = []byte("package a\n")
+	result.AssertGolden(r, dir)
+	assert.False(t, r.failed)
+}
@@ -0,0 +1,6 @@
+package shapes
+
+//go:generate ag
+
+// @Named
+type Shape int
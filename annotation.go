@@ -1,16 +1,25 @@
 package ag
 
 import (
+	"bytes"
+	"errors"
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/expgo/ag/api"
 	"github.com/expgo/structure"
+	"strings"
 	"text/scanner"
 )
 
 type Key struct {
 	Pos  lexer.Position
-	Text string `@Ident "="?`
+	Text string `@Ident (?! ".") "="?`
+}
+
+// Arg is a positional param value, like "DB_URL" in @Env("DB_URL", required).
+type Arg struct {
+	Pos   lexer.Position
+	Value structure.ValueWrapper `@@`
 }
 
 type Name struct {
@@ -18,6 +27,13 @@ type Name struct {
 	Text string `@Ident`
 }
 
+// QualifiedName is an annotation name, optionally qualified by the namespace
+// of its plugin, like Enum or enum.Enum.
+type QualifiedName struct {
+	Pos  lexer.Position
+	Text string `@(Ident ((?= "." Ident) "." Ident)?)`
+}
+
 type Comment struct {
 	Pos  lexer.Position
 	Text string `@Comment`
@@ -47,6 +63,7 @@ type ClosedParenthesis struct {
 }
 
 type Params struct {
+	Pos               lexer.Position
 	List              []*AnnotationParam `"(" @@*`
 	ClosedParenthesis ClosedParenthesis  `@@`
 }
@@ -71,6 +88,7 @@ type ClosedBracket struct {
 }
 
 type Extends struct {
+	Pos           lexer.Position
 	List          []*AnnotationExtend `"{" @@*`
 	ClosedBracket ClosedBracket       `@@`
 }
@@ -90,17 +108,43 @@ func (e Extends) toApi() []*api.AnnotationExtend {
 }
 
 type Annotation struct {
-	BeforeUseless *string    `(~(Comment | "@"))*`
-	Doc           []*Comment `@@*`
-	Name          Name       `"@" @@`
-	Params        *Params    `@@?`
-	Extends       *Extends   `@@?`
-	Comment       *Comment   `@@?`
-	AfterUseless  *string    `(~(Comment | "@"))*`
+	BeforeUseless *string       `(~(Comment | "@"))*`
+	Doc           []*Comment    `@@*`
+	Name          QualifiedName `"@" @@`
+	Params        *Params       `@@?`
+	Extends       *Extends      `@@?`
+	Comment       *Comment      `@@?`
+	AfterUseless  *string       `(~(Comment | "@"))*`
+}
+
+// trailingComment reports whether the annotation has a comment on its last
+// line.
+func (a *Annotation) trailingComment() bool {
+	return a.Comment != nil &&
+		(a.Comment.Pos.Line == a.Name.Pos.Line ||
+			(a.Params != nil && a.Params.ClosedParenthesis.Pos.Line == a.Comment.Pos.Line) ||
+			(a.Extends != nil && a.Extends.ClosedBracket.Pos.Line == a.Comment.Pos.Line))
+}
+
+// end returns the offset just after the annotation, its trailing comment
+// included.
+func (a *Annotation) end() int {
+	end := a.Name.Pos.Offset + len(a.Name.Text)
+	if a.Params != nil {
+		end = a.Params.ClosedParenthesis.Pos.Offset + 1
+	}
+	if a.Extends != nil {
+		end = a.Extends.ClosedBracket.Pos.Offset + 1
+	}
+	if a.trailingComment() {
+		end = a.Comment.Pos.Offset + len(a.Comment.Text)
+	}
+	return end
 }
 
 func (a *Annotation) toApi() *api.Annotation {
 	result := &api.Annotation{
+		Pos:     a.Name.Pos,
 		Doc:     toApiDoc(a.Doc),
 		Name:    a.Name.Text,
 		Comment: toApiComment(a.Comment),
@@ -117,21 +161,64 @@ func (a *Annotation) toApi() *api.Annotation {
 	return result
 }
 
+// AnnotationParam is either a keyed param, with an optional value, or a
+// positional one. A bare identifier is always parsed as a key.
 type AnnotationParam struct {
 	Pos     lexer.Position
 	Doc     []*Comment             `@@*`
-	Key     Key                    `@@`
-	Value   structure.ValueWrapper `@@? ","?`
+	Key     *Key                   `( @@`
+	Value   structure.ValueWrapper `  @@? ","?`
+	Arg     *Arg                   `| @@ ","? )`
 	Comment *Comment               `@@?`
+	EndPos  lexer.Position
+	Tokens  []lexer.Token
+}
+
+// start returns the offset of the key, or of the value of a positional param.
+func (ap *AnnotationParam) start() int {
+	if ap.Arg != nil {
+		return ap.Arg.Pos.Offset
+	}
+	return ap.Key.Pos.Offset
+}
+
+// end returns the offset just after the value of the param, or after its key
+// when it has no value, in text. Its comma and comment are not included.
+func (ap *AnnotationParam) end(text string) int {
+	end := ap.EndPos.Offset
+	for i := len(ap.Tokens) - 1; i >= 0; i-- {
+		tok := ap.Tokens[i]
+		if tok.Type != scanner.Comment && tok.Type != ',' {
+			break
+		}
+		end = tok.Pos.Offset
+	}
+	return len(strings.TrimRight(text[:end], " \t\r\n"))
+}
+
+func (ap *AnnotationParam) line() int {
+	if ap.Arg != nil {
+		return ap.Arg.Pos.Line
+	}
+	return ap.Key.Pos.Line
 }
 
 func (ap *AnnotationParam) toApi() *api.AnnotationParam {
-	return &api.AnnotationParam{
+	result := &api.AnnotationParam{
 		Doc:     toApiDoc(ap.Doc),
-		Key:     ap.Key.Text,
-		Value:   ap.Value,
 		Comment: toApiComment(ap.Comment),
 	}
+
+	if ap.Arg != nil {
+		result.Pos = ap.Arg.Pos
+		result.Value = toApiValue(ap.Arg.Value)
+	} else {
+		result.Pos = ap.Key.Pos
+		result.Key = ap.Key.Text
+		result.Value = toApiValue(ap.Value)
+	}
+
+	return result
 }
 
 type AnnotationExtend struct {
@@ -145,14 +232,76 @@ type AnnotationExtend struct {
 
 func (ae AnnotationExtend) toApi() *api.AnnotationExtend {
 	return &api.AnnotationExtend{
+		Pos:     ae.Name.Pos,
 		Doc:     toApiDoc(ae.Doc),
 		Name:    ae.Name.Text,
-		Values:  ae.Values,
-		Value:   ae.Value,
+		Values:  toApiValues(ae.Values),
+		Value:   toApiValue(ae.Value),
 		Comment: toApiComment(ae.Comment),
 	}
 }
 
+// AnnotationLiteral is an annotation used as a value, like the items of
+// middleware={@Auth(role=admin), @RateLimit(limit=10)}.
+type AnnotationLiteral struct {
+	Name    QualifiedName `"@" @@`
+	Params  *Params       `@@?`
+	Extends *Extends      `@@? ","?`
+}
+
+func (al AnnotationLiteral) Value() any {
+	return al.toApi()
+}
+
+func (al AnnotationLiteral) toApi() *api.Annotation {
+	result := &api.Annotation{
+		Pos:  al.Name.Pos,
+		Name: al.Name.Text,
+	}
+
+	if al.Params != nil {
+		result.Params = al.Params.toApi()
+	}
+
+	if al.Extends != nil {
+		result.Extends = al.Extends.toApi()
+	}
+
+	return result
+}
+
+// toApiValue replaces the annotation literals of a parsed value, at any
+// depth, with their api.AnnotationValue.
+func toApiValue(value structure.ValueWrapper) structure.ValueWrapper {
+	switch v := value.(type) {
+	case AnnotationLiteral:
+		return api.AnnotationValue{V: v.toApi()}
+	case api.Slice:
+		return api.Slice{V: toApiValues(v.V)}
+	case api.Map:
+		result := api.Map{}
+		for _, entry := range v.V {
+			result.V = append(result.V, &api.MapEntry{Key: entry.Key, Value: toApiValue(entry.Value)})
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+func toApiValues(values []structure.ValueWrapper) []structure.ValueWrapper {
+	if len(values) == 0 {
+		return nil
+	}
+
+	result := make([]structure.ValueWrapper, 0, len(values))
+	for _, value := range values {
+		result = append(result, toApiValue(value))
+	}
+
+	return result
+}
+
 func toApiDoc(comments []*Comment) []string {
 	if len(comments) == 0 {
 		return nil
@@ -174,12 +323,21 @@ func toApiComment(comment *Comment) string {
 	return comment.Text
 }
 
+// unquoteRawString strips the backticks of a raw string, without processing
+// escapes like participle.Unquote does.
+func unquoteRawString(token lexer.Token) (lexer.Token, error) {
+	token.Value = strings.TrimSuffix(strings.TrimPrefix(token.Value, "`"), "`")
+	return token, nil
+}
+
 var annotationParser = participle.MustBuild[Annotations](
 	participle.Lexer(lexer.NewTextScannerLexer(func(s *scanner.Scanner) {
-		s.Mode &^= scanner.SkipComments
+		// no value is a char, an apostrophe of prose is a token of its own
+		s.Mode &^= scanner.SkipComments | scanner.ScanChars
 	})),
-	participle.Union[structure.ValueWrapper](api.Bool{}, api.Float{}, api.Int{}, api.Uint{}, api.String{}, api.Slice{}),
+	participle.Union[structure.ValueWrapper](api.Bool{}, api.Nil{}, api.Duration{}, api.Float{}, api.Int{}, api.Uint{}, api.String{}, api.Ident{}, api.Map{}, api.Slice{}, AnnotationLiteral{}),
 	participle.Unquote("String"),
+	participle.Map(unquoteRawString, "RawString"),
 )
 
 func fixComments(annotations *Annotations, err error) (*api.Annotations, error) {
@@ -187,11 +345,19 @@ func fixComments(annotations *Annotations, err error) (*api.Annotations, error)
 		return nil, err
 	}
 
+	moveComments(annotations)
+
+	return annotations.toApi(), err
+}
+
+// moveComments moves the comments that are not on the line of what they
+// follow to the doc of what comes next.
+func moveComments(annotations *Annotations) {
 	for ai, annotation := range annotations.Annotations {
 		if annotation.Params != nil {
 			for pi, param := range annotation.Params.List {
 				if param.Comment != nil &&
-					param.Comment.Pos.Line != param.Key.Pos.Line &&
+					param.Comment.Pos.Line != param.line() &&
 					pi+1 < len(annotation.Params.List) {
 					annotation.Params.List[pi+1].Doc = append([]*Comment{param.Comment}, annotation.Params.List[pi+1].Doc...)
 					param.Comment = nil
@@ -210,19 +376,107 @@ func fixComments(annotations *Annotations, err error) (*api.Annotations, error)
 			}
 		}
 
-		if annotation.Comment != nil &&
-			!(annotation.Comment.Pos.Line == annotation.Name.Pos.Line ||
-				(annotation.Params != nil && annotation.Params.ClosedParenthesis.Pos.Line == annotation.Comment.Pos.Line) ||
-				(annotation.Extends != nil && annotation.Extends.ClosedBracket.Pos.Line == annotation.Comment.Pos.Line)) &&
-			ai+1 < len(annotations.Annotations) {
+		if annotation.Comment != nil && !annotation.trailingComment() && ai+1 < len(annotations.Annotations) {
 			annotations.Annotations[ai+1].Doc = append([]*Comment{annotation.Comment}, annotations.Annotations[ai+1].Doc...)
 			annotation.Comment = nil
 		}
 	}
-
-	return annotations.toApi(), err
 }
 
 func ParseAnnotation(fileName string, text string) (*api.Annotations, error) {
-	return fixComments(annotationParser.ParseString(fileName, text))
+	annotations, err := annotationParser.ParseString(fileName, text)
+	if err != nil {
+		return nil, newParseError(err, text, text, textOrigin{})
+	}
+	return fixComments(annotations, nil)
+}
+
+// ParseAnnotationRecover parses text like ParseAnnotation, but does not stop
+// at the first malformed annotation. The error is recorded, the annotation is
+// skipped up to the next one starting a line, and parsing goes on. It returns
+// the valid annotations along with every error.
+func ParseAnnotationRecover(fileName string, text string) (*api.Annotations, []error) {
+	return parseAnnotationRecover(fileName, text, text, textOrigin{})
+}
+
+// parseAnnotationRecover parses text, which has the same offsets as source,
+// and reports the annotations and errors at their positions in the file text
+// starts at origin of.
+func parseAnnotationRecover(fileName string, text string, source string, origin textOrigin) (*api.Annotations, []error) {
+	var errs []error
+	buf := []byte(text)
+
+	for bytes.IndexByte(buf, '@') >= 0 {
+		annotations, err := annotationParser.ParseBytes(fileName, buf)
+		if err == nil {
+			result, _ := fixComments(annotations, nil)
+			origin.shift(result)
+			return result, errs
+		}
+
+		errs = append(errs, newParseError(err, string(buf), source, origin))
+
+		var perr participle.Error
+		if !errors.As(err, &perr) || !skipAnnotation(buf, perr.Position().Offset) {
+			break
+		}
+	}
+
+	return &api.Annotations{}, errs
+}
+
+// skipAnnotation blanks out the annotation that failed to parse at offset,
+// keeping the line breaks so that positions are unchanged. When a bracket is
+// left open before offset, the annotation that opened it is skipped instead.
+// It returns false if there was nothing left to skip.
+func skipAnnotation(buf []byte, offset int) bool {
+	if offset > len(buf) {
+		offset = len(buf)
+	}
+
+	anchor := offset
+	if openers := unclosedBrackets(string(buf[:offset])); len(openers) > 0 {
+		anchor = openers[0].pos.Offset
+	}
+
+	start := -1
+	for i := anchor - 1; i >= 0; i-- {
+		if buf[i] == '@' && (start < 0 || startsLine(buf, i)) {
+			start = i
+			if startsLine(buf, i) {
+				break
+			}
+		}
+	}
+	if start < 0 {
+		start = bytes.LastIndexByte(buf[:anchor], '\n') + 1
+	}
+
+	end := len(buf)
+	for i := anchor + 1; i < len(buf); i++ {
+		if buf[i] == '@' && startsLine(buf, i) {
+			end = i
+			break
+		}
+	}
+
+	skipped := false
+	for i := start; i < end; i++ {
+		if buf[i] != '\n' && buf[i] != ' ' {
+			buf[i] = ' '
+			skipped = true
+		}
+	}
+
+	return skipped
+}
+
+// startsLine reports whether only spaces precede buf[i] on its line.
+func startsLine(buf []byte, i int) bool {
+	for j := i - 1; j >= 0 && buf[j] != '\n'; j-- {
+		if buf[j] != ' ' && buf[j] != '\t' && buf[j] != '\r' {
+			return false
+		}
+	}
+	return true
 }
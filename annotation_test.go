@@ -1,10 +1,12 @@
 package ag
 
 import (
+	"errors"
 	"github.com/expgo/ag/api"
 	"github.com/expgo/structure"
 	"github.com/google/go-cmp/cmp"
 	"testing"
+	"time"
 )
 
 func TestParseAnnotation(t *testing.T) {
@@ -136,11 +138,11 @@ sql comment2
 							},
 							{
 								Key:   "int",
-								Value: api.Int{V: 123},
+								Value: api.Int{V: 123, Text: "123"},
 							},
 							{
 								Key:   "double",
-								Value: api.Float{V: 456.7},
+								Value: api.Float{V: 456.7, Text: "456.7"},
 							},
 							{
 								Key:   "bool",
@@ -148,7 +150,7 @@ sql comment2
 							},
 							{
 								Key:   "params",
-								Value: api.Slice{V: []structure.ValueWrapper{api.String{V: "abc"}, api.Int{V: 321}, api.Float{V: 123.4}, api.Bool{V: false}}},
+								Value: api.Slice{V: []structure.ValueWrapper{api.String{V: "abc"}, api.Int{V: 321, Text: "321"}, api.Float{V: 123.4, Text: "123.4"}, api.Bool{V: false}}},
 							},
 						},
 					},
@@ -157,15 +159,15 @@ sql comment2
 						Params: []*api.AnnotationParam{
 							{
 								Key:   "code",
-								Value: api.String{V: "int32"},
+								Value: api.Ident{V: "int32"},
 							},
 							{
 								Key:   "name",
-								Value: api.String{V: "string"},
+								Value: api.Ident{V: "string"},
 							},
 							{
 								Key:   "message",
-								Value: api.String{V: "string"},
+								Value: api.Ident{V: "string"},
 							},
 						},
 					},
@@ -200,11 +202,11 @@ sql comment2
 							},
 							{
 								Key:   "int",
-								Value: api.Int{V: 123},
+								Value: api.Int{V: 123, Text: "123"},
 							},
 							{
 								Key:   "double",
-								Value: api.Float{V: 456.7},
+								Value: api.Float{V: 456.7, Text: "456.7"},
 							},
 							{
 								Key:   "bool",
@@ -217,7 +219,7 @@ sql comment2
 							},
 							{
 								Name:  "GoodWithIntValue",
-								Value: api.Int{V: 12},
+								Value: api.Int{V: 12, Text: "12"},
 							},
 							{
 								Name:  "GoodWithStrValue",
@@ -227,8 +229,8 @@ sql comment2
 								Name: "GoodWithParams",
 								Values: []structure.ValueWrapper{
 									api.String{V: "string"},
-									api.Int{V: 123},
-									api.Float{V: 456.7},
+									api.Int{V: 123, Text: "123"},
+									api.Float{V: 456.7, Text: "456.7"},
 									api.Bool{V: true},
 								},
 								Comment: singleComment,
@@ -237,11 +239,11 @@ sql comment2
 								Name: "GoodWithAll",
 								Values: []structure.ValueWrapper{
 									api.String{V: "string"},
-									api.Int{V: 123},
-									api.Float{V: 456.7},
+									api.Int{V: 123, Text: "123"},
+									api.Float{V: 456.7, Text: "456.7"},
 									api.Bool{V: false},
 								},
-								Value:   api.Int{V: 89},
+								Value:   api.Int{V: 89, Text: "89"},
 								Comment: multiComment,
 							},
 						},
@@ -251,15 +253,15 @@ sql comment2
 						Params: []*api.AnnotationParam{
 							{
 								Key:   "code",
-								Value: api.String{V: "int32"},
+								Value: api.Ident{V: "int32"},
 							},
 							{
 								Key:   "name",
-								Value: api.String{V: "string"},
+								Value: api.Ident{V: "string"},
 							},
 							{
 								Key:   "message",
-								Value: api.String{V: "string"},
+								Value: api.Ident{V: "string"},
 							},
 						},
 					},
@@ -340,11 +342,11 @@ tag comment 4
     */`,
 								},
 								Key:   "int",
-								Value: api.Int{V: 123},
+								Value: api.Int{V: 123, Text: "123"},
 							},
 							{
 								Key:     "double",
-								Value:   api.Float{V: 456.7},
+								Value:   api.Float{V: 456.7, Text: "456.7"},
 								Comment: "// double inline comment",
 							},
 							{
@@ -367,7 +369,7 @@ tag comment 4
        comment 3 */`,
 								},
 								Name:  "GoodWithIntValue",
-								Value: api.Int{V: 12},
+								Value: api.Int{V: 12, Text: "12"},
 							},
 							{
 								Name:  "GoodWithStrValue",
@@ -377,8 +379,8 @@ tag comment 4
 								Name: "GoodWithParams",
 								Values: []structure.ValueWrapper{
 									api.String{V: "string"},
-									api.Int{V: 123},
-									api.Float{V: 456.7},
+									api.Int{V: 123, Text: "123"},
+									api.Float{V: 456.7, Text: "456.7"},
 									api.Bool{V: true},
 								},
 								Comment: singleComment,
@@ -387,11 +389,11 @@ tag comment 4
 								Name: "GoodWithAll",
 								Values: []structure.ValueWrapper{
 									api.String{V: "string"},
-									api.Int{V: 123},
-									api.Float{V: 456.7},
+									api.Int{V: 123, Text: "123"},
+									api.Float{V: 456.7, Text: "456.7"},
 									api.Bool{V: false},
 								},
-								Value:   api.Int{V: 89},
+								Value:   api.Int{V: 89, Text: "89"},
 								Comment: multiComment,
 							},
 						},
@@ -407,15 +409,15 @@ tag comment 4
 						Params: []*api.AnnotationParam{
 							{
 								Key:   "code",
-								Value: api.String{V: "int32"},
+								Value: api.Ident{V: "int32"},
 							},
 							{
 								Key:   "name",
-								Value: api.String{V: "string"},
+								Value: api.Ident{V: "string"},
 							},
 							{
 								Key:   "message",
-								Value: api.String{V: "string"},
+								Value: api.Ident{V: "string"},
 							},
 						},
 						Comment: "// sql inline comment",
@@ -424,6 +426,151 @@ tag comment 4
 			},
 			wantErr: false,
 		},
+		{
+			name:     "annotation with map and nested slice params",
+			fileName: "file.go",
+			text:     `@tag(labels = {env = "prod", "zone" = 3}, matrix = {{1, 2}, {3}}, limit = {rate = 10, tags = {a, b}})`,
+			want: &api.Annotations{
+				Annotations: []*api.Annotation{
+					{
+						Name: "tag",
+						Params: []*api.AnnotationParam{
+							{
+								Key: "labels",
+								Value: api.Map{V: []*api.MapEntry{
+									{Key: "env", Value: api.String{V: "prod"}},
+									{Key: "zone", Value: api.Int{V: 3, Text: "3"}},
+								}},
+							},
+							{
+								Key: "matrix",
+								Value: api.Slice{V: []structure.ValueWrapper{
+									api.Slice{V: []structure.ValueWrapper{api.Int{V: 1, Text: "1"}, api.Int{V: 2, Text: "2"}}},
+									api.Slice{V: []structure.ValueWrapper{api.Int{V: 3, Text: "3"}}},
+								}},
+							},
+							{
+								Key: "limit",
+								Value: api.Map{V: []*api.MapEntry{
+									{Key: "rate", Value: api.Int{V: 10, Text: "10"}},
+									{Key: "tags", Value: api.Slice{V: []structure.ValueWrapper{api.Ident{V: "a"}, api.Ident{V: "b"}}}},
+								}},
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "annotation with rich value literals",
+			fileName: "file.go",
+			text: "@tag(raw = `a\\b`, timeout = 1h30m, delay = -5s, ptr = nil, hex = 0x1F, oct = 0o17, bin = 0b101, " +
+				"method = http.MethodGet, mode = fast, labels = {env: prod, \"zone\": 3})",
+			want: &api.Annotations{
+				Annotations: []*api.Annotation{
+					{
+						Name: "tag",
+						Params: []*api.AnnotationParam{
+							{Key: "raw", Value: api.String{V: `a\b`}},
+							{Key: "timeout", Value: api.Duration{V: 90 * time.Minute, Text: "1h30m"}},
+							{Key: "delay", Value: api.Duration{V: -5 * time.Second, Text: "-5s"}},
+							{Key: "ptr", Value: api.Nil{V: true}},
+							{Key: "hex", Value: api.Int{V: 0x1F, Text: "0x1F"}},
+							{Key: "oct", Value: api.Int{V: 0o17, Text: "0o17"}},
+							{Key: "bin", Value: api.Int{V: 0b101, Text: "0b101"}},
+							{Key: "method", Value: api.Ident{V: "http.MethodGet"}},
+							{Key: "mode", Value: api.Ident{V: "fast"}},
+							{
+								Key: "labels",
+								Value: api.Map{V: []*api.MapEntry{
+									{Key: "env", Value: api.Ident{V: "prod"}},
+									{Key: "zone", Value: api.Int{V: 3, Text: "3"}},
+								}},
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "annotation with nested annotation values",
+			fileName: "file.go",
+			text:     `@Route(path="/x", middleware={@Auth(role=admin), @RateLimit(limit=10, burst={@Burst(size=2)})})`,
+			want: &api.Annotations{
+				Annotations: []*api.Annotation{
+					{
+						Name: "Route",
+						Params: []*api.AnnotationParam{
+							{Key: "path", Value: api.String{V: "/x"}},
+							{
+								Key: "middleware",
+								Value: api.Slice{V: []structure.ValueWrapper{
+									api.AnnotationValue{V: &api.Annotation{
+										Name:   "Auth",
+										Params: []*api.AnnotationParam{{Key: "role", Value: api.Ident{V: "admin"}}},
+									}},
+									api.AnnotationValue{V: &api.Annotation{
+										Name: "RateLimit",
+										Params: []*api.AnnotationParam{
+											{Key: "limit", Value: api.Int{V: 10, Text: "10"}},
+											{Key: "burst", Value: api.Slice{V: []structure.ValueWrapper{
+												api.AnnotationValue{V: &api.Annotation{
+													Name:   "Burst",
+													Params: []*api.AnnotationParam{{Key: "size", Value: api.Int{V: 2, Text: "2"}}},
+												}},
+											}}},
+										},
+									}},
+								}},
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "annotation with positional params",
+			fileName: "file.go",
+			text:     `@Env("DB_URL", required, time.Second, default = "x", 10)`,
+			want: &api.Annotations{
+				Annotations: []*api.Annotation{
+					{
+						Name: "Env",
+						Params: []*api.AnnotationParam{
+							{Value: api.String{V: "DB_URL"}},
+							{Key: "required"},
+							{Value: api.Ident{V: "time.Second"}},
+							{Key: "default", Value: api.String{V: "x"}},
+							{Value: api.Int{V: 10, Text: "10"}},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "annotation with namespaced names",
+			fileName: "file.go",
+			text: `@enum.Enum(auth = @myco.Auth) see @Enum.
+`,
+			want: &api.Annotations{
+				Annotations: []*api.Annotation{
+					{
+						Name: "enum.Enum",
+						Params: []*api.AnnotationParam{
+							{Key: "auth", Value: api.AnnotationValue{V: &api.Annotation{Name: "myco.Auth"}}},
+						},
+					},
+					{
+						Name: "Enum",
+					},
+				},
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -443,6 +590,66 @@ tag comment 4
 	}
 }
 
+type routeMiddleware struct {
+	Role  string
+	Limit int
+	Burst []*routeMiddleware
+	Size  int
+}
+
+type route struct {
+	Path       string
+	Middleware []routeMiddleware
+}
+
+func TestParseAnnotationTo(t *testing.T) {
+	ans, err := ParseAnnotation("file.go", `@Route(path="/x", middleware={@Auth(role=admin), @RateLimit(limit=10, burst={@Burst(size=2)})})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &route{}
+	if err = ans.FindAnnotationByName("Route").To(r); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &route{
+		Path: "/x",
+		Middleware: []routeMiddleware{
+			{Role: "admin"},
+			{Limit: 10, Burst: []*routeMiddleware{{Size: 2}}},
+		},
+	}
+	if diff := cmp.Diff(want, r); len(diff) > 0 {
+		t.Errorf("Annotation.To() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+type env struct {
+	Name     string `ag:"0"`
+	Unit     string `ag:"1"`
+	Limit    int    `ag:"2"`
+	Required bool
+	Fallback string `ag:"default"`
+}
+
+func TestParseAnnotationPositionalTo(t *testing.T) {
+	ans, err := ParseAnnotation("file.go", `@Env("DB_URL", required, time.Second, default = "x", 10)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &env{}
+	if err = ans.FindAnnotationByName("Env").To(e); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &env{Name: "DB_URL", Unit: "time.Second", Limit: 10, Required: true, Fallback: "x"}
+	if diff := cmp.Diff(want, e); len(diff) > 0 {
+		t.Errorf("Annotation.To() mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func ignorePosFields(path cmp.Path) bool {
 	// 遍历路径中的每个步骤
 	for _, step := range path {
@@ -456,3 +663,105 @@ func ignorePosFields(path cmp.Path) bool {
 	// 对于其他字段，不忽略
 	return false
 }
+
+func TestParseAnnotationError(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		line   int
+		column int
+		hint   string
+	}{
+		{
+			name:   "unclosed parenthesis",
+			text:   "@tag(a = 1\n",
+			line:   2,
+			column: 1,
+			hint:   `"(" opened at 1:5 is never closed`,
+		},
+		{
+			name:   "unclosed bracket",
+			text:   "@Enum {\n\tA = 1\n",
+			line:   3,
+			column: 1,
+			hint:   `"{" opened at 1:7 is never closed`,
+		},
+		{
+			name:   "missing assign",
+			text:   "@Enum { A 1 }",
+			line:   1,
+			column: 11,
+			hint:   `missing "=" between "A" and its value`,
+		},
+		{
+			name:   "unterminated string",
+			text:   `@tag(a = "abc)`,
+			line:   1,
+			column: 10,
+			hint:   "add the closing quote, strings can not span lines",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAnnotation("file.go", tt.text)
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseAnnotation() error = %v, want a ParseError", err)
+			}
+
+			if parseErr.Pos.Line != tt.line || parseErr.Pos.Column != tt.column {
+				t.Errorf("ParseAnnotation() error at %d:%d, want %d:%d", parseErr.Pos.Line, parseErr.Pos.Column, tt.line, tt.column)
+			}
+
+			if parseErr.Hint != tt.hint {
+				t.Errorf("ParseAnnotation() hint = %q, want %q", parseErr.Hint, tt.hint)
+			}
+		})
+	}
+}
+
+func TestParseAnnotationRecover(t *testing.T) {
+	text := `
+@tag(a = 1
+@ok(b = 2)
+@Enum {
+	A 1
+}
+@last
+`
+	got, errs := ParseAnnotationRecover("file.go", text)
+
+	if len(errs) != 2 {
+		t.Fatalf("ParseAnnotationRecover() got %d errors, want 2: %v", len(errs), errs)
+	}
+
+	// @ok and @Enum are read as positional params of @tag, so the first
+	// error is found in @Enum, but the hint points to the cause.
+	wantHints := []string{
+		`"(" opened at 2:5 is never closed`,
+		`missing "=" between "A" and its value`,
+	}
+	for i, err := range errs {
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("ParseAnnotationRecover() error = %v, want a ParseError", err)
+		}
+		if parseErr.Hint != wantHints[i] {
+			t.Errorf("ParseAnnotationRecover() error %d hint = %q, want %q", i, parseErr.Hint, wantHints[i])
+		}
+	}
+
+	want := &api.Annotations{
+		Annotations: []*api.Annotation{
+			{Name: "ok", Params: []*api.AnnotationParam{{Key: "b", Value: api.Int{V: 2, Text: "2"}}}},
+			{Name: "last"},
+		},
+	}
+
+	opt := cmp.FilterPath(ignorePosFields, cmp.Ignore())
+	if diff := cmp.Diff(want, got, opt); len(diff) > 0 {
+		t.Errorf("ParseAnnotationRecover() mismatch (-want +got):\n%s", diff)
+	}
+}
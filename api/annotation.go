@@ -2,9 +2,15 @@ package api
 
 import (
 	"errors"
+	"fmt"
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/expgo/structure"
 	"reflect"
+	"strconv"
 	"strings"
+	"text/scanner"
+	"time"
 )
 
 type Annotations struct {
@@ -12,6 +18,7 @@ type Annotations struct {
 }
 
 type Annotation struct {
+	Pos     lexer.Position
 	Doc     []string
 	Name    string
 	Params  []*AnnotationParam
@@ -19,14 +26,23 @@ type Annotation struct {
 	Comment string
 }
 
+// AnnotationParam is a keyed param like key = value, or a positional param,
+// which has an empty Key.
 type AnnotationParam struct {
+	Pos     lexer.Position
 	Doc     []string
 	Key     string
 	Value   structure.ValueWrapper
 	Comment string
 }
 
+// IsPositional reports whether the param was given without a key.
+func (ap *AnnotationParam) IsPositional() bool {
+	return len(ap.Key) == 0
+}
+
 type AnnotationExtend struct {
+	Pos     lexer.Position
 	Doc     []string
 	Name    string
 	Values  []structure.ValueWrapper
@@ -34,36 +50,174 @@ type AnnotationExtend struct {
 	Comment string
 }
 
+// Float is a floating-point literal like 1.5 or 1e3. Text is the literal as
+// written in the source, kept when formatting, and empty for values built in
+// code.
 type Float struct {
-	V float64 `@Float ","? `
+	V    float64
+	Text string
 }
 
 func (f Float) Value() any { return f.V }
 
+// Parse implements participle.Parseable, to keep the text of the literal.
+func (f *Float) Parse(lex *lexer.PeekingLexer) error {
+	text, err := parseNumber(lex, false, scanner.Float, func(text string) (err error) {
+		f.V, err = strconv.ParseFloat(text, 64)
+		return
+	})
+	f.Text = text
+	return err
+}
+
+// Int is an integer literal like 10, -1 or 0x1F, with its Text like Float.
 type Int struct {
-	V int `@(("-" | "+")? Int) ","? `
+	V    int
+	Text string
 }
 
 func (i Int) Value() any {
 	return i.V
 }
 
+// Parse implements participle.Parseable, to keep the text of the literal.
+func (i *Int) Parse(lex *lexer.PeekingLexer) error {
+	text, err := parseNumber(lex, true, scanner.Int, func(text string) error {
+		v, err := strconv.ParseInt(text, 0, strconv.IntSize)
+		i.V = int(v)
+		return err
+	})
+	i.Text = text
+	return err
+}
+
+// Uint is an integer literal too large for an Int, with its Text like Float.
 type Uint struct {
-	V uint `@Int ","? `
+	V    uint
+	Text string
 }
 
 func (u Uint) Value() any {
 	return u.V
 }
 
+// Parse implements participle.Parseable, to keep the text of the literal.
+func (u *Uint) Parse(lex *lexer.PeekingLexer) error {
+	text, err := parseNumber(lex, false, scanner.Int, func(text string) error {
+		v, err := strconv.ParseUint(text, 0, strconv.IntSize)
+		u.V = uint(v)
+		return err
+	})
+	u.Text = text
+	return err
+}
+
+// parseNumber reads a number token of type tokenType, preceded by a sign when
+// signed, and an optional ",". It returns the text of the number, or
+// participle.NextMatch with lex unchanged when convert fails on it.
+func parseNumber(lex *lexer.PeekingLexer, signed bool, tokenType lexer.TokenType, convert func(text string) error) (string, error) {
+	checkpoint := lex.MakeCheckpoint()
+
+	sign := ""
+	if tok := lex.Peek(); signed && (tok.Value == "-" || tok.Value == "+") {
+		sign = lex.Next().Value
+	}
+
+	num := lex.Peek()
+	if num.Type != tokenType || convert(sign+num.Value) != nil {
+		lex.LoadCheckpoint(checkpoint)
+		return "", participle.NextMatch
+	}
+	lex.Next()
+
+	if lex.Peek().Value == "," {
+		lex.Next()
+	}
+
+	return sign + num.Value, nil
+}
+
 type String struct {
-	V string `@(String | Ident) ","? `
+	V string `@(String | RawString) ","? `
 }
 
 func (s String) Value() any {
 	return s.V
 }
 
+// Ident is a bare or qualified Go identifier like required, int32 or
+// http.MethodGet. Unlike String, it is meant to be emitted as code.
+type Ident struct {
+	V string `@(Ident ("." Ident)*) ","? `
+}
+
+func (i Ident) Value() any {
+	return i.V
+}
+
+// Code returns the identifier as Go source.
+func (i Ident) Code() string {
+	return i.V
+}
+
+type Nil struct {
+	V bool `@"nil" ","? `
+}
+
+func (n Nil) Value() any {
+	return nil
+}
+
+// Duration is a number directly followed by a time unit, like 5s or 1h30m,
+// with its Text like Float.
+type Duration struct {
+	V    time.Duration
+	Text string
+}
+
+func (d Duration) Value() any {
+	return d.V
+}
+
+// Parse implements participle.Parseable, as the number and the unit must not
+// be separated by spaces.
+func (d *Duration) Parse(lex *lexer.PeekingLexer) error {
+	checkpoint := lex.MakeCheckpoint()
+
+	sign := ""
+	if tok := lex.Peek(); tok.Value == "-" || tok.Value == "+" {
+		sign = lex.Next().Value
+	}
+
+	num := lex.Peek()
+	if num.Type != scanner.Int && num.Type != scanner.Float {
+		lex.LoadCheckpoint(checkpoint)
+		return participle.NextMatch
+	}
+	lex.Next()
+
+	unit := lex.Peek()
+	if unit.Type != scanner.Ident || unit.Pos.Offset != num.Pos.Offset+len(num.Value) {
+		lex.LoadCheckpoint(checkpoint)
+		return participle.NextMatch
+	}
+
+	v, err := time.ParseDuration(sign + num.Value + unit.Value)
+	if err != nil {
+		lex.LoadCheckpoint(checkpoint)
+		return participle.NextMatch
+	}
+	lex.Next()
+
+	if lex.Peek().Value == "," {
+		lex.Next()
+	}
+
+	d.V = v
+	d.Text = sign + num.Value + unit.Value
+	return nil
+}
+
 type Boolean bool
 
 func (b *Boolean) Capture(values []string) error {
@@ -80,64 +234,127 @@ func (b Bool) Value() any {
 }
 
 type Slice struct {
-	V []structure.ValueWrapper `"{" @@* "}"`
+	V []structure.ValueWrapper `"{" @@* "}" ","?`
 }
 
 func (s Slice) Value() any {
 	return s.V
 }
 
+type MapEntry struct {
+	Key   string                 `@(String | Ident) ("=" | ":")`
+	Value structure.ValueWrapper `@@`
+}
+
+// Map is a key/value grouping like { name = "x", size: 10 }. It decodes into
+// map[string]T, structs and pointers to structs.
+type Map struct {
+	V []*MapEntry `"{" (?= (String | Ident) ("=" | ":")) @@+ "}" ","?`
+}
+
+func (m Map) Value() any {
+	result := make(map[string]structure.ValueWrapper, len(m.V))
+	for _, entry := range m.V {
+		result[entry.Key] = entry.Value
+	}
+	return result
+}
+
+// AnnotationValue is an annotation used as a value. It decodes into structs
+// and pointers to structs like the params of Annotation.To.
+type AnnotationValue struct {
+	V *Annotation
+}
+
+func (av AnnotationValue) Value() any {
+	return av.V
+}
+
 var defaultBoolValue = Bool{V: true}
 
-func (a *Annotation) To(t any) (err error) {
+// Args returns the values of the positional params, in order.
+func (a *Annotation) Args() []structure.ValueWrapper {
+	var result []structure.ValueWrapper
+	for _, p := range a.Params {
+		if p.IsPositional() {
+			result = append(result, p.Value)
+		}
+	}
+	return result
+}
+
+/*
+To decodes the annotation params into the struct pointed by t. Keyed params
+are matched to fields by name, case-insensitively. The ag tag binds a field
+to another key or to a positional param:
+
+	ag:"path"  the param with key path
+	ag:"0"     the positional param at index 0
+	ag:"-"     ignored
+
+Slices, maps, structs and pointers to structs are decoded recursively from
+Slice, Map and AnnotationValue values.
+*/
+func (a *Annotation) To(t any) error {
 	if t == nil {
 		return errors.New("the input parameter cannot be nil")
 	}
 
-	if a.Params != nil {
-		err = structure.WalkField(t, func(fieldValue reflect.Value, structField reflect.StructField, rootValues []reflect.Value) error {
-			switch fieldValue.Kind() {
-			case reflect.Ptr, reflect.Struct:
-				return nil
-			default:
-			}
+	val := reflect.ValueOf(t)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return errors.New("the input parameter must be a non-nil pointer")
+	}
 
-			var ap *AnnotationParam = nil
+	val = val.Elem()
+	if val.Kind() != reflect.Struct {
+		return errors.New("the input parameter must point to a struct")
+	}
 
-			for _, p := range a.Params {
-				if strings.EqualFold(structField.Name, p.Key) {
-					ap = p
-					break
-				}
-			}
+	return a.decodeTo(val)
+}
 
-			if ap == nil {
-				return nil
-			}
+func (a *Annotation) decodeTo(val reflect.Value) error {
+	if a.Params == nil {
+		return nil
+	}
 
-			if fieldValue.Kind() == reflect.Bool && ap.Value == nil {
-				ap.Value = defaultBoolValue
+	err := decodeStruct(val, func(key string, index int) (structure.ValueWrapper, bool) {
+		if index >= 0 {
+			if args := a.Args(); index < len(args) {
+				return args[index], true
 			}
+			return nil, false
+		}
 
-			if ap.Value != nil {
-				value := structure.MustConvertToType(ap.Value, fieldValue.Type())
-				if structure.SetFieldBySetMethod(fieldValue, value, structField, rootValues[len(rootValues)-1]) {
-					return nil
-				}
-				return structure.SetField(fieldValue, value)
+		for _, p := range a.Params {
+			if !p.IsPositional() && strings.EqualFold(key, p.Key) {
+				return p.Value, true
 			}
-
-			return nil
-		})
+		}
+		return nil, false
+	})
+	if err != nil {
+		return fmt.Errorf("annotation @%s: %w", a.Name, err)
 	}
 
-	return
+	return nil
+}
+
+// SplitName splits a qualified annotation name like enum.Enum into its
+// namespace and its short name. An unqualified name has no namespace.
+func SplitName(name string) (namespace string, short string) {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
 }
 
+// FindAnnotationByName returns the first annotation named name. Names are
+// case-sensitive.
 func (anns *Annotations) FindAnnotationByName(name string) *Annotation {
 	if len(anns.Annotations) > 0 {
 		for _, a := range anns.Annotations {
-			if strings.EqualFold(a.Name, name) {
+			if a.Name == name {
 				return a
 			}
 		}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"github.com/expgo/structure"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type toLimit struct {
+	Rate  int
+	Burst int
+}
+
+type toBase struct {
+	Name string
+}
+
+type toOptions struct {
+	toBase
+	Tags    []string
+	Ports   []int
+	Labels  map[string]string
+	Limit   toLimit
+	Backoff *toLimit
+	Enabled bool
+	Timeout time.Duration
+	Method  string
+}
+
+func TestAnnotationTo(t *testing.T) {
+	an := &Annotation{
+		Name: "opts",
+		Params: []*AnnotationParam{
+			{Key: "name", Value: String{V: "svc"}},
+			{Key: "tags", Value: Slice{V: []structure.ValueWrapper{String{V: "a"}, String{V: "b"}}}},
+			{Key: "ports", Value: Slice{V: []structure.ValueWrapper{Int{V: 80}, Int{V: 443}}}},
+			{Key: "labels", Value: Map{V: []*MapEntry{{Key: "env", Value: String{V: "prod"}}}}},
+			{Key: "limit", Value: Map{V: []*MapEntry{{Key: "rate", Value: Int{V: 10}}, {Key: "burst", Value: Int{V: 20}}}}},
+			{Key: "backoff", Value: Map{V: []*MapEntry{{Key: "rate", Value: Int{V: 1}}}}},
+			{Key: "enabled"},
+			{Key: "timeout", Value: Duration{V: 5 * time.Second}},
+			{Key: "method", Value: Ident{V: "http.MethodGet"}},
+		},
+	}
+
+	opts := &toOptions{}
+	assert.NoError(t, an.To(opts))
+
+	assert.Equal(t, &toOptions{
+		toBase:  toBase{Name: "svc"},
+		Tags:    []string{"a", "b"},
+		Ports:   []int{80, 443},
+		Labels:  map[string]string{"env": "prod"},
+		Limit:   toLimit{Rate: 10, Burst: 20},
+		Backoff: &toLimit{Rate: 1},
+		Enabled: true,
+		Timeout: 5 * time.Second,
+		Method:  "http.MethodGet",
+	}, opts)
+
+	reset := &Annotation{Name: "opts", Params: []*AnnotationParam{{Key: "backoff", Value: Nil{V: true}}}}
+	assert.NoError(t, reset.To(opts))
+	assert.Nil(t, opts.Backoff)
+}
+
+func TestAnnotationToError(t *testing.T) {
+	an := &Annotation{
+		Name:   "opts",
+		Params: []*AnnotationParam{{Key: "ports", Value: Slice{V: []structure.ValueWrapper{String{V: "http"}}}}},
+	}
+
+	err := an.To(&toOptions{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "@opts")
+	assert.Contains(t, err.Error(), "Ports")
+}
+
+type toSizes struct {
+	Small  int8
+	Count  uint
+	Limits map[string]int
+	Limit  toLimit
+}
+
+func TestAnnotationToRange(t *testing.T) {
+	for _, tt := range []struct {
+		value structure.ValueWrapper
+		key   string
+		field string
+	}{
+		{Int{V: 1000}, "small", "Small"},
+		{Int{V: -129}, "small", "Small"},
+		{Int{V: -1}, "count", "Count"},
+	} {
+		an := &Annotation{Name: "sizes", Params: []*AnnotationParam{{Key: tt.key, Value: tt.value}}}
+		err := an.To(&toSizes{})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), tt.field)
+			assert.Contains(t, err.Error(), "overflows")
+		}
+	}
+
+	sizes := &toSizes{}
+	an := &Annotation{Name: "sizes", Params: []*AnnotationParam{
+		{Key: "small", Value: Int{V: -128}},
+		{Key: "count", Value: Uint{V: 1 << 40}},
+	}}
+	assert.NoError(t, an.To(sizes))
+	assert.Equal(t, int8(-128), sizes.Small)
+	assert.Equal(t, uint(1<<40), sizes.Count)
+}
+
+func TestAnnotationToEmptyBraces(t *testing.T) {
+	// {} is parsed as an empty Slice
+	an := &Annotation{Name: "sizes", Params: []*AnnotationParam{
+		{Key: "limits", Value: Slice{}},
+		{Key: "limit", Value: Slice{}},
+	}}
+
+	sizes := &toSizes{Limit: toLimit{Rate: 1}}
+	assert.NoError(t, an.To(sizes))
+	assert.Equal(t, map[string]int{}, sizes.Limits)
+	assert.Equal(t, toLimit{Rate: 1}, sizes.Limit)
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// DeclGenerator is an optional interface of Generator, whose declarations are
+// printed by ag with go/printer. The const declarations are written with the
+// consts of the other generators, merged in one block when they don't use
+// iota, the others after the body of the generator.
+type DeclGenerator interface {
+	Decls() ([]ast.Decl, error)
+}
+
+// Field is a struct field, a param, a result or a receiver. A field without
+// Name is embedded, or unnamed.
+type Field struct {
+	Name string
+	Type string
+	Tag  string
+	Doc  string
+}
+
+// ConstSpec is a const of a const block. Type and Value may be empty, to
+// repeat the previous ones, like with iota.
+type ConstSpec struct {
+	Name  string
+	Type  string
+	Value string
+	Doc   string
+}
+
+/*
+Code builds declarations, for a DeclGenerator. Types, values and bodies are
+written as Go source, which is parsed when it is added, so a syntax error is
+reported with the declaration it belongs to:
+
+	code := &api.Code{}
+	code.Const(api.ConstSpec{Name: "ColorRed", Type: "Color", Value: "iota"}, api.ConstSpec{Name: "ColorGreen"})
+	code.Method(api.Field{Name: "x", Type: "Color"}, "IsRed", nil, []api.Field{{Type: "bool"}}, "return x == ColorRed")
+	decls, err := code.Decls()
+*/
+type Code struct {
+	decls []ast.Decl
+	errs  []string
+}
+
+// Decl adds declarations built by other means.
+func (c *Code) Decl(decls ...ast.Decl) *Code {
+	c.decls = append(c.decls, decls...)
+	return c
+}
+
+// Const adds a const block.
+func (c *Code) Const(specs ...ConstSpec) *Code {
+	decl := &ast.GenDecl{Tok: token.CONST}
+	if len(specs) > 1 {
+		decl.Lparen = 1
+	}
+
+	for _, s := range specs {
+		spec := &ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(s.Name)}, Doc: doc(s.Doc)}
+		if len(s.Type) > 0 {
+			spec.Type = c.expr("const "+s.Name, s.Type)
+		}
+		if len(s.Value) > 0 {
+			spec.Values = []ast.Expr{c.expr("const "+s.Name, s.Value)}
+		}
+		decl.Specs = append(decl.Specs, spec)
+	}
+
+	c.decls = append(c.decls, decl)
+	return c
+}
+
+// Struct adds a struct type.
+func (c *Code) Struct(name string, docText string, fields ...Field) *Code {
+	c.decls = append(c.decls, &ast.GenDecl{
+		Tok: token.TYPE,
+		Doc: doc(docText),
+		Specs: []ast.Spec{&ast.TypeSpec{
+			Name: ast.NewIdent(name),
+			Type: &ast.StructType{Fields: c.fields("type "+name, fields)},
+		}},
+	})
+	return c
+}
+
+// Func adds a func, body being its statements.
+func (c *Code) Func(name string, params []Field, results []Field, body string) *Code {
+	c.decls = append(c.decls, c.funcDecl(nil, name, params, results, body))
+	return c
+}
+
+// Method adds a method of recv.
+func (c *Code) Method(recv Field, name string, params []Field, results []Field, body string) *Code {
+	c.decls = append(c.decls, c.funcDecl(&recv, name, params, results, body))
+	return c
+}
+
+// Decls returns the declarations built, or the syntax errors of the source
+// they were built from.
+func (c *Code) Decls() ([]ast.Decl, error) {
+	if len(c.errs) > 0 {
+		return nil, fmt.Errorf("code: %s", strings.Join(c.errs, "; "))
+	}
+	return c.decls, nil
+}
+
+func (c *Code) funcDecl(recv *Field, name string, params []Field, results []Field, body string) *ast.FuncDecl {
+	decl := &ast.FuncDecl{
+		Name: ast.NewIdent(name),
+		Type: &ast.FuncType{Params: c.fields("func "+name, params)},
+	}
+
+	if recv != nil {
+		decl.Recv = c.fields("func "+name, []Field{*recv})
+	}
+	if len(results) > 0 {
+		decl.Type.Results = c.fields("func "+name, results)
+	}
+
+	// parsed as the body of a func literal, to allow any statement
+	src := "func() {\n" + body + "\n}"
+	if lit, ok := c.expr("func "+name, src).(*ast.FuncLit); ok {
+		decl.Body = lit.Body
+	} else {
+		decl.Body = &ast.BlockStmt{}
+	}
+
+	return decl
+}
+
+func (c *Code) fields(context string, fields []Field) *ast.FieldList {
+	result := &ast.FieldList{}
+	for _, f := range fields {
+		field := &ast.Field{Type: c.expr(context, f.Type), Doc: doc(f.Doc)}
+		if len(f.Name) > 0 {
+			field.Names = []*ast.Ident{ast.NewIdent(f.Name)}
+		}
+		if len(f.Tag) > 0 {
+			tag := "`" + f.Tag + "`"
+			if strings.Contains(f.Tag, "`") {
+				tag = strconv.Quote(f.Tag)
+			}
+			field.Tag = &ast.BasicLit{Kind: token.STRING, Value: tag}
+		}
+		result.List = append(result.List, field)
+	}
+	return result
+}
+
+// expr parses src, recording its error in the context of a declaration.
+func (c *Code) expr(context string, src string) ast.Expr {
+	expr, err := parser.ParseExpr(src)
+	if err != nil {
+		c.errs = append(c.errs, fmt.Sprintf("%s: %q: %s", context, src, err))
+		return ast.NewIdent("_")
+	}
+	return expr
+}
+
+func doc(text string) *ast.CommentGroup {
+	if len(text) == 0 {
+		return nil
+	}
+
+	cg := &ast.CommentGroup{}
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		cg.List = append(cg.List, &ast.Comment{Slash: 1, Text: strings.TrimRight("// "+line, " ")})
+	}
+	return cg
+}
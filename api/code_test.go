@@ -0,0 +1,43 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go/ast"
+	"testing"
+)
+
+func TestCode(t *testing.T) {
+	code := &Code{}
+	code.Const(ConstSpec{Name: "ColorRed", Type: "Color", Value: "iota", Doc: "ColorRed is red."}, ConstSpec{Name: "ColorGreen"})
+	code.Struct("Palette", "Palette holds colors.",
+		Field{Name: "Colors", Type: "[]Color", Tag: `json:"colors"`},
+		Field{Type: "sync.Mutex"},
+	)
+	code.Func("ParseColor", []Field{{Name: "name", Type: "string"}}, []Field{{Type: "Color"}, {Type: "error"}}, `
+		if name == "red" {
+			return ColorRed, nil
+		}
+		return 0, fmt.Errorf("invalid color %s", name)`)
+	code.Method(Field{Name: "x", Type: "*Color"}, "IsRed", nil, []Field{{Type: "bool"}}, "return *x == ColorRed")
+
+	decls, err := code.Decls()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.Len(t, decls, 4) {
+		return
+	}
+	palette := decls[1].(*ast.GenDecl)
+	assert.Equal(t, "Palette holds colors.\n", palette.Doc.Text())
+	fields := palette.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List
+	assert.Equal(t, "`json:\"colors\"`", fields[0].Tag.Value)
+	assert.Nil(t, fields[1].Names)
+
+	code = &Code{}
+	code.Const(ConstSpec{Name: "A", Value: "1 +"})
+	code.Func("F", nil, nil, "return )")
+	_, err = code.Decls()
+	assert.ErrorContains(t, err, `const A: "1 +"`)
+	assert.ErrorContains(t, err, `func F:`)
+}
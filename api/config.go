@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+)
+
+// ConfigFileName is the name of the ag config file, read from the module root,
+// next to go.mod.
+const ConfigFileName = "ag.yaml"
+
+// Config is the per module ag configuration.
+type Config struct {
+	// Detect tells how annotations are found in comments, lineStart by
+	// default. Loose is permissive, an @ and a name in prose is taken as an
+	// annotation too.
+	Detect DetectMode `yaml:"detect"`
+	// Aliases maps short annotation names to qualified ones, like
+	// Enum: enum.Enum, picking a plugin when several claim the same name.
+	Aliases map[string]string `yaml:"aliases"`
+	// Header is written at the top of the generated files, before the
+	// generated code notice, like a license banner. Lines which are not
+	// comments are commented.
+	Header string `yaml:"header"`
+	// Build is a build constraint expression, like linux && !race, added to
+	// the one copied from the source file to the generated files.
+	Build string `yaml:"build"`
+}
+
+// LoadConfig reads the config file of the module at moduleDir. A module
+// without a config file gets the default config.
+func LoadConfig(moduleDir string) (*Config, error) {
+	return LoadConfigFS(OS, moduleDir)
+}
+
+// LoadConfigFS is LoadConfig reading the config file from fsys.
+func LoadConfigFS(fsys FS, moduleDir string) (*Config, error) {
+	config := &Config{Detect: DetectModeLineStart}
+
+	filename := filepath.Join(moduleDir, ConfigFileName)
+	data, err := fsys.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return nil, err
+	}
+
+	if err = yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return config, nil
+}
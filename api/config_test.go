@@ -0,0 +1,31 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	config, err := LoadConfig(dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, DetectModeLineStart, config.Detect)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, ConfigFileName), []byte("detect: marker\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err = LoadConfig(dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, DetectModeMarker, config.Detect)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, ConfigFileName), []byte("detect: everywhere\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig(dir)
+	assert.ErrorIs(t, err, ErrInvalidDetectMode)
+}
@@ -0,0 +1,27 @@
+package api
+
+// GenerateContext is what a generator may know about the generation beyond
+// its own annotations.
+type GenerateContext struct {
+	PackageName string
+	PackagePath string // the import path of the package
+	OutputFile  string // the absolute path of the generated file
+	FileInfo    *FileInfo
+	// Annotations are all the annotations parsed, of every plugin, sorted by
+	// file then by position.
+	Annotations []*TypedAnnotation
+	Config      *Config
+	Diagnostics *Diagnostics
+	// Symbols are the symbols declared by the generators created before, with
+	// SymbolProvider, to avoid writing the same names.
+	Symbols *SymbolTable
+	// Imports are the imports of the generated file, to get the names of the
+	// packages the generator uses.
+	Imports *Imports
+}
+
+// ContextFactory is an optional interface of GeneratorFactory, whose
+// generator is then created by NewWithContext instead of New.
+type ContextFactory interface {
+	NewWithContext(ctx *GenerateContext, tas []*TypedAnnotation) (Generator, error)
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"fmt"
+	"github.com/expgo/structure"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+var annotationType = reflect.TypeOf(Annotation{})
+
+// decodeValue decodes an annotation value into to, walking into slices, maps,
+// structs and pointers. Scalar values are converted by the structure package.
+func decodeValue(value structure.ValueWrapper, to reflect.Value) error {
+	if value == nil {
+		return nil
+	}
+
+	if _, ok := value.(Nil); ok {
+		to.Set(reflect.Zero(to.Type()))
+		return nil
+	}
+
+	switch to.Kind() {
+	case reflect.Ptr:
+		if to.IsNil() {
+			to.Set(reflect.New(to.Type().Elem()))
+		}
+		return decodeValue(value, to.Elem())
+	case reflect.Struct:
+		switch v := value.(type) {
+		case Slice:
+			// {} is an empty slice to the parser
+			if len(v.V) == 0 {
+				return nil
+			}
+		case Map:
+			return decodeMapToStruct(v, to)
+		case AnnotationValue:
+			if to.Type() == annotationType {
+				to.Set(reflect.ValueOf(*v.V))
+				return nil
+			}
+			return v.V.decodeTo(to)
+		}
+	case reflect.Slice:
+		if s, ok := value.(Slice); ok {
+			return decodeSlice(s, to)
+		}
+	case reflect.Map:
+		switch v := value.(type) {
+		case Map:
+			return decodeMap(v, to)
+		case Slice:
+			if len(v.V) == 0 {
+				to.Set(reflect.MakeMap(to.Type()))
+				return nil
+			}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if err := checkRange(value, to); err != nil {
+			return err
+		}
+	default:
+	}
+
+	converted, err := structure.ConvertToType(value, to.Type())
+	if err != nil {
+		return err
+	}
+	to.Set(reflect.ValueOf(converted))
+	return nil
+}
+
+// checkRange returns an error if the integer value overflows the integer
+// kind of to, which the conversion would wrap silently.
+func checkRange(value structure.ValueWrapper, to reflect.Value) error {
+	overflows := false
+	switch v := value.(type) {
+	case Int:
+		if to.CanInt() {
+			overflows = to.OverflowInt(int64(v.V))
+		} else {
+			overflows = v.V < 0 || to.OverflowUint(uint64(v.V))
+		}
+	case Uint:
+		if to.CanInt() {
+			overflows = uint64(v.V) > 1<<63-1 || to.OverflowInt(int64(v.V))
+		} else {
+			overflows = to.OverflowUint(uint64(v.V))
+		}
+	}
+
+	if overflows {
+		return fmt.Errorf("%v overflows %s", value.Value(), to.Type())
+	}
+	return nil
+}
+
+func decodeSlice(s Slice, to reflect.Value) error {
+	result := reflect.MakeSlice(to.Type(), len(s.V), len(s.V))
+	for i, v := range s.V {
+		if err := decodeValue(v, result.Index(i)); err != nil {
+			return fmt.Errorf("[%d]: %w", i, err)
+		}
+	}
+	to.Set(result)
+	return nil
+}
+
+func decodeMap(m Map, to reflect.Value) error {
+	mapType := to.Type()
+	if mapType.Key().Kind() != reflect.String {
+		return fmt.Errorf("unsupported map key type %s, only string keys are supported", mapType.Key())
+	}
+
+	result := reflect.MakeMapWithSize(mapType, len(m.V))
+	for _, entry := range m.V {
+		elem := reflect.New(mapType.Elem()).Elem()
+		if err := decodeValue(entry.Value, elem); err != nil {
+			return fmt.Errorf("%s: %w", entry.Key, err)
+		}
+		result.SetMapIndex(reflect.ValueOf(entry.Key).Convert(mapType.Key()), elem)
+	}
+	to.Set(result)
+	return nil
+}
+
+func decodeMapToStruct(m Map, to reflect.Value) error {
+	return decodeStruct(to, func(key string, index int) (structure.ValueWrapper, bool) {
+		if index >= 0 {
+			return nil, false
+		}
+
+		for _, entry := range m.V {
+			if strings.EqualFold(key, entry.Key) {
+				return entry.Value, true
+			}
+		}
+		return nil, false
+	})
+}
+
+// fieldBinding returns the key of a struct field, which is its name unless
+// set by the ag tag, or its positional index when the tag is a number.
+func fieldBinding(structField reflect.StructField) (key string, index int, skip bool) {
+	tag, ok := structField.Tag.Lookup(TagName)
+	if !ok || len(tag) == 0 {
+		return structField.Name, -1, false
+	}
+
+	if tag == "-" {
+		return "", -1, true
+	}
+
+	if idx, err := strconv.Atoi(tag); err == nil && idx >= 0 {
+		return "", idx, false
+	}
+
+	return tag, -1, false
+}
+
+// decodeStruct sets every field of the struct to for which lookup returns a
+// value. Embedded structs are flattened, so their fields share the namespace
+// of the outer struct. If the struct has a Set<Field> method, it is used
+// instead of assigning the field directly.
+func decodeStruct(to reflect.Value, lookup func(key string, index int) (structure.ValueWrapper, bool)) error {
+	structType := to.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		fieldValue := to.Field(i)
+
+		if structField.Anonymous {
+			embedded := fieldValue
+			if embedded.Kind() == reflect.Ptr && !embedded.IsNil() {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := decodeStruct(embedded, lookup); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		key, index, skip := fieldBinding(structField)
+		if skip {
+			continue
+		}
+
+		value, ok := lookup(key, index)
+		if !ok {
+			continue
+		}
+
+		if fieldValue.Kind() == reflect.Bool && value == nil {
+			value = defaultBoolValue
+		}
+
+		if value == nil {
+			continue
+		}
+
+		decoded := reflect.New(structField.Type).Elem()
+		decoded.Set(accessible(fieldValue))
+		if err := decodeValue(value, decoded); err != nil {
+			return fmt.Errorf("field %s: %w", structField.Name, err)
+		}
+
+		if structure.SetFieldBySetMethod(fieldValue, decoded.Interface(), structField, to) {
+			continue
+		}
+
+		accessible(fieldValue).Set(decoded)
+	}
+
+	return nil
+}
+
+// accessible returns a settable view of an addressable field, including
+// unexported ones.
+func accessible(fieldValue reflect.Value) reflect.Value {
+	if fieldValue.CanSet() {
+		return fieldValue
+	}
+	return reflect.NewAt(fieldValue.Type(), unsafe.Pointer(fieldValue.UnsafeAddr())).Elem()
+}
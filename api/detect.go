@@ -0,0 +1,21 @@
+package api
+
+//go:generate ag
+
+/*
+DetectMode tells how annotations are told apart from prose in comments.
+
+	@EnumConfig(marshal, noCase)
+	@Enum {
+		// Loose finds annotations anywhere in a comment, even in prose
+		// like "the @Enum of Color".
+		loose
+		// LineStart only finds annotations starting a comment line, the
+		// default.
+		lineStart
+		// Marker only finds annotations written after an //ag: or +ag: marker,
+		// like //ag:Enum or // +ag:Enum, the marker standing for the @.
+		marker
+	}
+*/
+type DetectMode int
@@ -0,0 +1,94 @@
+// Code generated by https://github.com/expgo/ag DO NOT EDIT.
+// Plugins:
+//   - github.com/expgo/enum
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DetectModeLoose is a DetectMode of type loose.
+	// Loose finds annotations anywhere in a comment, even in prose
+	// like "the @Enum of Color".
+	DetectModeLoose DetectMode = iota
+	// DetectModeLineStart is a DetectMode of type lineStart.
+	// LineStart only finds annotations starting a comment line, the
+	// default.
+	DetectModeLineStart
+	// DetectModeMarker is a DetectMode of type marker.
+	// Marker only finds annotations written after an //ag: or +ag: marker,
+	// like //ag:Enum or // +ag:Enum, the marker standing for the @.
+	DetectModeMarker
+)
+
+var ErrInvalidDetectMode = errors.New("not a valid DetectMode")
+
+var _DetectModeName = "looselineStartmarker"
+
+var _DetectModeMapName = map[DetectMode]string{
+	DetectModeLoose:     _DetectModeName[0:5],
+	DetectModeLineStart: _DetectModeName[5:14],
+	DetectModeMarker:    _DetectModeName[14:20],
+}
+
+// Name is the attribute of DetectMode.
+func (x DetectMode) Name() string {
+	if v, ok := _DetectModeMapName[x]; ok {
+		return v
+	}
+	return fmt.Sprintf("DetectMode(%d).Name", x)
+}
+
+// Val is the attribute of DetectMode.
+func (x DetectMode) Val() int {
+	return int(x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x DetectMode) IsValid() bool {
+	_, ok := _DetectModeMapName[x]
+	return ok
+}
+
+// String implements the Stringer interface.
+func (x DetectMode) String() string {
+	return x.Name()
+}
+
+var _DetectModeNameMap = map[string]DetectMode{
+	_DetectModeName[0:5]:                   DetectModeLoose,
+	_DetectModeName[5:14]:                  DetectModeLineStart,
+	strings.ToLower(_DetectModeName[5:14]): DetectModeLineStart,
+	_DetectModeName[14:20]:                 DetectModeMarker,
+}
+
+// ParseDetectMode converts a string to a DetectMode.
+func ParseDetectMode(value string) (DetectMode, error) {
+	if x, ok := _DetectModeNameMap[value]; ok {
+		return x, nil
+	}
+	if x, ok := _DetectModeNameMap[strings.ToLower(value)]; ok {
+		return x, nil
+	}
+	return DetectMode(0), fmt.Errorf("%s is %w", value, ErrInvalidDetectMode)
+}
+
+// MarshalText implements the text marshaller method.
+func (x DetectMode) MarshalText() ([]byte, error) {
+	return []byte(x.String()), nil
+}
+
+// UnmarshalText implements the text unmarshaller method.
+func (x *DetectMode) UnmarshalText(text []byte) error {
+	val, err := ParseDetectMode(string(text))
+	if err != nil {
+		return err
+	}
+	*x = val
+	return nil
+}
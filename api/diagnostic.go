@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle/v2/lexer"
+	"sync"
+)
+
+//go:generate ag
+
+/*
+Severity tells whether a Diagnostic is a warning, or an error which stops the
+generation.
+
+	@Enum {warning, error}
+*/
+type Severity int
+
+// Diagnostic is a warning or an error of a generator, at a position of the
+// sources, like the one of an annotation.
+type Diagnostic struct {
+	Pos      lexer.Position
+	Severity Severity
+	Message  string
+}
+
+// String returns the diagnostic as file:line:col: severity: message, without
+// the position if it is unknown.
+func (d *Diagnostic) String() string {
+	if d.Pos.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Pos, d.Severity, d.Message)
+}
+
+// Diagnostics collects the diagnostics of a generation. It is safe for
+// concurrent use.
+type Diagnostics struct {
+	mutex sync.Mutex
+	list  []*Diagnostic
+}
+
+// Report adds d.
+func (ds *Diagnostics) Report(d *Diagnostic) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	ds.list = append(ds.list, d)
+}
+
+// Warnf reports a warning at pos.
+func (ds *Diagnostics) Warnf(pos lexer.Position, format string, args ...any) {
+	ds.Report(&Diagnostic{Pos: pos, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// Errorf reports an error at pos.
+func (ds *Diagnostics) Errorf(pos lexer.Position, format string, args ...any) {
+	ds.Report(&Diagnostic{Pos: pos, Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+}
+
+// List returns the diagnostics reported so far, in reporting order.
+func (ds *Diagnostics) List() []*Diagnostic {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	return append([]*Diagnostic{}, ds.list...)
+}
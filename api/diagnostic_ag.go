@@ -0,0 +1,64 @@
+// Code generated by https://github.com/expgo/ag DO NOT EDIT.
+// Plugins:
+//   - github.com/expgo/enum
+
+package api
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// SeverityWarning is a Severity of type warning.
+	SeverityWarning Severity = iota
+	// SeverityError is a Severity of type error.
+	SeverityError
+)
+
+var ErrInvalidSeverity = errors.New("not a valid Severity")
+
+var _SeverityName = "warningerror"
+
+var _SeverityMapName = map[Severity]string{
+	SeverityWarning: _SeverityName[0:7],
+	SeverityError:   _SeverityName[7:12],
+}
+
+// Name is the attribute of Severity.
+func (x Severity) Name() string {
+	if v, ok := _SeverityMapName[x]; ok {
+		return v
+	}
+	return fmt.Sprintf("Severity(%d).Name", x)
+}
+
+// Val is the attribute of Severity.
+func (x Severity) Val() int {
+	return int(x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x Severity) IsValid() bool {
+	_, ok := _SeverityMapName[x]
+	return ok
+}
+
+// String implements the Stringer interface.
+func (x Severity) String() string {
+	return x.Name()
+}
+
+var _SeverityNameMap = map[string]Severity{
+	_SeverityName[0:7]:  SeverityWarning,
+	_SeverityName[7:12]: SeverityError,
+}
+
+// ParseSeverity converts a string to a Severity.
+func ParseSeverity(value string) (Severity, error) {
+	if x, ok := _SeverityNameMap[value]; ok {
+		return x, nil
+	}
+	return Severity(0), fmt.Errorf("%s is %w", value, ErrInvalidSeverity)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"github.com/alecthomas/participle/v2/lexer"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// TagName is the struct tag used to bind annotation parts to struct fields.
+const TagName = "ag"
+
+// DecodeError is an error raised while decoding an annotation, with the
+// position of the offending annotation part.
+type DecodeError struct {
+	Pos lexer.Position
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Pos, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+/*
+DecodeExtends decodes every extend of an annotation body into a T, which
+must be a struct or a pointer to a struct. Fields are bound with the ag tag:
+
+	ag:"name"    the extend name
+	ag:"value"   the value after "="
+	ag:"0"       the positional value at index 0 inside "(...)"
+	ag:"doc"     the doc comments, as a string joined by "\n" or a []string
+	ag:"comment" the trailing comment
+	ag:"-"       ignored
+
+Untagged fields named Name, Value, Doc or Comment are bound to the matching
+part, other untagged fields are ignored.
+*/
+func DecodeExtends[T any](extends []*AnnotationExtend) ([]T, error) {
+	result := make([]T, 0, len(extends))
+
+	for _, extend := range extends {
+		var item T
+		to := reflect.ValueOf(&item).Elem()
+		if to.Kind() == reflect.Ptr {
+			to.Set(reflect.New(to.Type().Elem()))
+			to = to.Elem()
+		}
+
+		if to.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("DecodeExtends: %s is not a struct", to.Type())
+		}
+
+		if err := decodeExtend(extend, to); err != nil {
+			return nil, &DecodeError{Pos: extend.Pos, Err: fmt.Errorf("extend %s: %w", extend.Name, err)}
+		}
+
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+func decodeExtend(extend *AnnotationExtend, to reflect.Value) error {
+	structType := to.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		fieldValue := accessible(to.Field(i))
+
+		part, ok := structField.Tag.Lookup(TagName)
+		if !ok {
+			part = strings.ToLower(structField.Name)
+		}
+
+		var err error
+		switch part {
+		case "-":
+		case "name":
+			err = decodeValue(String{V: extend.Name}, fieldValue)
+		case "value":
+			err = decodeValue(extend.Value, fieldValue)
+		case "doc":
+			switch {
+			case fieldValue.Kind() == reflect.Slice && fieldValue.Type().Elem().Kind() == reflect.String:
+				fieldValue.Set(reflect.ValueOf(extend.Doc).Convert(fieldValue.Type()))
+			case fieldValue.Kind() == reflect.Slice:
+				err = fmt.Errorf("doc can't be decoded into a %s", fieldValue.Type())
+			default:
+				err = decodeValue(String{V: strings.Join(extend.Doc, "\n")}, fieldValue)
+			}
+		case "comment":
+			err = decodeValue(String{V: extend.Comment}, fieldValue)
+		default:
+			if !ok {
+				continue
+			}
+
+			idx, convErr := strconv.Atoi(part)
+			if convErr != nil || idx < 0 {
+				return fmt.Errorf("field %s: invalid %s tag %q", structField.Name, TagName, part)
+			}
+
+			if idx < len(extend.Values) {
+				err = decodeValue(extend.Values[idx], fieldValue)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("field %s: %w", structField.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/expgo/structure"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type extendItem struct {
+	Name    string
+	Value   int
+	Label   string  `ag:"0"`
+	Weight  float64 `ag:"1"`
+	Doc     []string
+	Comment string
+	Skipped string `ag:"-"`
+}
+
+func TestDecodeExtends(t *testing.T) {
+	extends := []*AnnotationExtend{
+		{Name: "A", Value: Int{V: 1}, Doc: []string{"// doc"}},
+		{Name: "B", Values: []structure.ValueWrapper{String{V: "b"}, Float{V: 0.5}}, Comment: "// comment"},
+	}
+
+	items, err := DecodeExtends[extendItem](extends)
+	assert.NoError(t, err)
+	assert.Equal(t, []extendItem{
+		{Name: "A", Value: 1, Doc: []string{"// doc"}},
+		{Name: "B", Label: "b", Weight: 0.5, Comment: "// comment"},
+	}, items)
+
+	ptrItems, err := DecodeExtends[*extendItem](extends[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, "A", ptrItems[0].Name)
+}
+
+func TestDecodeExtendsError(t *testing.T) {
+	extends := []*AnnotationExtend{
+		{Pos: lexer.Position{Filename: "file.go", Line: 3, Column: 2}, Name: "A", Values: []structure.ValueWrapper{String{V: "a"}, String{V: "heavy"}}},
+	}
+
+	_, err := DecodeExtends[extendItem](extends)
+	assert.Error(t, err)
+
+	var decodeErr *DecodeError
+	assert.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, 3, decodeErr.Pos.Line)
+	assert.Contains(t, err.Error(), "file.go:3:2")
+	assert.Contains(t, err.Error(), "Weight")
+}
+
+func TestDecodeExtendsDocError(t *testing.T) {
+	type docItem struct {
+		Doc []int `ag:"doc"`
+	}
+	extends := []*AnnotationExtend{
+		{Pos: lexer.Position{Filename: "file.go", Line: 4, Column: 2}, Name: "A", Doc: []string{"a doc"}},
+	}
+
+	_, err := DecodeExtends[docItem](extends)
+
+	var decodeErr *DecodeError
+	if assert.ErrorAs(t, err, &decodeErr) {
+		assert.Equal(t, 4, decodeErr.Pos.Line)
+		assert.Contains(t, err.Error(), "[]int")
+	}
+}
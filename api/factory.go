@@ -1,6 +1,7 @@
 package api
 
 import (
+	"github.com/alecthomas/participle/v2/lexer"
 	"go/ast"
 )
 
@@ -25,7 +26,23 @@ type TypedAnnotation struct {
 	FileInfo    *FileInfo
 }
 
+// Position returns the position of the first annotation of ta, to report
+// diagnostics at, or the zero position if ta has none.
+func (ta *TypedAnnotation) Position() lexer.Position {
+	if ta.Annotations == nil || len(ta.Annotations.Annotations) == 0 {
+		return lexer.Position{}
+	}
+	return ta.Annotations.Annotations[0].Pos
+}
+
 type GeneratorFactory interface {
 	Annotations() map[string][]AnnotationType // a map of name -> []AnnotationType
 	New([]*TypedAnnotation) (Generator, error)
 }
+
+// Namespaced is an optional interface of GeneratorFactory, naming the
+// namespace its annotations are qualified by, like the enum of enum.Enum. The
+// namespace defaults to the last element of the factory package path.
+type Namespaced interface {
+	Namespace() string
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"fmt"
+	"github.com/expgo/structure"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format renders the annotations back to canonical text, one after the other.
+func (anns *Annotations) Format() string {
+	sb := &strings.Builder{}
+	for _, a := range anns.Annotations {
+		sb.WriteString(a.Format())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+/*
+Format renders the annotation back to canonical text, keeping its Doc and
+Comment lines:
+
+  - params go on one line, or one per line when some of them have comments
+  - extends go one per line, with their "=" and comments aligned
+  - strings are double quoted, and commas only end the lines of params
+
+Parsing the result gives the same annotation back.
+*/
+func (a *Annotation) Format() string {
+	sb := &strings.Builder{}
+	for _, doc := range a.Doc {
+		sb.WriteString(doc)
+		sb.WriteString("\n")
+	}
+	a.format(sb, "", false)
+	return sb.String()
+}
+
+// FormatInline renders the annotation on one line, for the comments following
+// code. It reports false if the annotation has comments inside, which can not
+// fit on one line.
+func (a *Annotation) FormatInline() (string, bool) {
+	if a.hasParamComments() {
+		return "", false
+	}
+	for _, e := range a.Extends {
+		if len(e.Doc) > 0 || len(e.Comment) > 0 {
+			return "", false
+		}
+	}
+
+	sb := &strings.Builder{}
+	a.format(sb, "", true)
+	writeComment(sb, a.Comment)
+	return sb.String(), true
+}
+
+// format writes the annotation with its continuation lines indented by
+// indent. An inline annotation, used as a value, is kept on one line and
+// without comments.
+func (a *Annotation) format(sb *strings.Builder, indent string, inline bool) {
+	sb.WriteString("@")
+	sb.WriteString(a.Name)
+
+	if len(a.Params) > 0 {
+		if !inline && a.hasParamComments() {
+			sb.WriteString("(\n")
+			for _, p := range a.Params {
+				writeDoc(sb, indent+"\t", p.Doc)
+				sb.WriteString(indent + "\t" + p.format() + ",")
+				writeComment(sb, p.Comment)
+				sb.WriteString("\n")
+			}
+			sb.WriteString(indent + ")")
+		} else {
+			params := make([]string, 0, len(a.Params))
+			for _, p := range a.Params {
+				params = append(params, p.format())
+			}
+			sb.WriteString("(" + strings.Join(params, ", ") + ")")
+		}
+	}
+
+	if len(a.Extends) > 0 {
+		if inline {
+			extends := make([]string, 0, len(a.Extends))
+			for _, e := range a.Extends {
+				extends = append(extends, e.format(0))
+			}
+			sb.WriteString(" {" + strings.Join(extends, ", ") + "}")
+		} else {
+			sb.WriteString(" {\n")
+			formatExtends(sb, indent+"\t", a.Extends)
+			sb.WriteString(indent + "}")
+		}
+	}
+
+	if !inline {
+		writeComment(sb, a.Comment)
+	}
+}
+
+func (a *Annotation) hasParamComments() bool {
+	for _, p := range a.Params {
+		if len(p.Doc) > 0 || len(p.Comment) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (ap *AnnotationParam) format() string {
+	switch {
+	case ap.IsPositional():
+		return FormatValue(ap.Value)
+	case ap.Value == nil:
+		return ap.Key
+	default:
+		return ap.Key + " = " + FormatValue(ap.Value)
+	}
+}
+
+// Format renders the extend on one line, as in an extends block, with its
+// comment but without its doc.
+func (ae *AnnotationExtend) Format() string {
+	sb := &strings.Builder{}
+	sb.WriteString(ae.format(0))
+	writeComment(sb, ae.Comment)
+	return sb.String()
+}
+
+// format returns the extend, with its name and values padded to width.
+func (ae *AnnotationExtend) format(width int) string {
+	text := ae.head()
+	if ae.Value != nil {
+		text = fmt.Sprintf("%-*s = %s", width, text, FormatValue(ae.Value))
+	}
+	return text
+}
+
+// head returns the name and the values of the extend, the part before "=".
+func (ae *AnnotationExtend) head() string {
+	if len(ae.Values) == 0 {
+		return ae.Name
+	}
+
+	values := make([]string, 0, len(ae.Values))
+	for _, v := range ae.Values {
+		values = append(values, FormatValue(v))
+	}
+	return ae.Name + "(" + strings.Join(values, ", ") + ")"
+}
+
+func formatExtends(sb *strings.Builder, indent string, extends []*AnnotationExtend) {
+	width := 0
+	for _, e := range extends {
+		if e.Value != nil && len(e.head()) > width {
+			width = len(e.head())
+		}
+	}
+
+	lines := make([]string, 0, len(extends))
+	commentWidth := 0
+	for _, e := range extends {
+		line := e.format(width)
+		lines = append(lines, line)
+		if len(e.Comment) > 0 && len(line) > commentWidth {
+			commentWidth = len(line)
+		}
+	}
+
+	for i, e := range extends {
+		writeDoc(sb, indent, e.Doc)
+		if len(e.Comment) > 0 {
+			sb.WriteString(fmt.Sprintf("%s%-*s %s\n", indent, commentWidth, lines[i], e.Comment))
+		} else {
+			sb.WriteString(indent + lines[i] + "\n")
+		}
+	}
+}
+
+func writeDoc(sb *strings.Builder, indent string, doc []string) {
+	for _, d := range doc {
+		sb.WriteString(indent + d + "\n")
+	}
+}
+
+func writeComment(sb *strings.Builder, comment string) {
+	if len(comment) > 0 {
+		sb.WriteString(" " + comment)
+	}
+}
+
+// FormatValue renders a param or extend value as annotation text.
+func FormatValue(value structure.ValueWrapper) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case String:
+		return strconv.Quote(v.V)
+	case Float:
+		if len(v.Text) > 0 {
+			return v.Text
+		}
+		s := strconv.FormatFloat(v.V, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEIN") {
+			s += ".0"
+		}
+		return s
+	case Int:
+		if len(v.Text) > 0 {
+			return v.Text
+		}
+		return strconv.Itoa(v.V)
+	case Uint:
+		if len(v.Text) > 0 {
+			return v.Text
+		}
+		return strconv.FormatUint(uint64(v.V), 10)
+	case Bool:
+		return strconv.FormatBool(bool(v.V))
+	case Nil:
+		return "nil"
+	case Ident:
+		return v.V
+	case Duration:
+		if len(v.Text) > 0 {
+			return v.Text
+		}
+		return formatDuration(v.V)
+	case Slice:
+		values := make([]string, 0, len(v.V))
+		for _, item := range v.V {
+			values = append(values, FormatValue(item))
+		}
+		return "{" + strings.Join(values, ", ") + "}"
+	case Map:
+		entries := make([]string, 0, len(v.V))
+		for _, entry := range v.V {
+			entries = append(entries, formatMapKey(entry.Key)+" = "+FormatValue(entry.Value))
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	case AnnotationValue:
+		sb := &strings.Builder{}
+		v.V.format(sb, "", true)
+		return sb.String()
+	default:
+		return fmt.Sprint(value.Value())
+	}
+}
+
+// formatDuration is time.Duration.String without its zero minutes and
+// seconds, like 1h30m for 1h30m0s.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+func formatMapKey(key string) string {
+	for i, c := range key {
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			return strconv.Quote(key)
+		}
+	}
+	if len(key) == 0 {
+		return strconv.Quote(key)
+	}
+	return key
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FS is the file system ag reads the sources, go.mod and ag.yaml from. Names
+// are file paths of the OS, made absolute against the working directory when
+// they are relative.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	Stat(name string) (fs.FileInfo, error)
+	Glob(pattern string) ([]string, error)
+}
+
+// WriteFS is an FS the generated files are written to. A read only FS, like
+// the one of MountFS, is made writable in memory with NewOverlay.
+type WriteFS interface {
+	FS
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	Remove(name string) error
+}
+
+// OS is the file system of the OS.
+var OS WriteFS = osFS{}
+
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error)  { return os.ReadFile(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+func (osFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+func (osFS) Remove(name string) error              { return os.Remove(name) }
+
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, perm)
+}
+
+// OrOS returns fsys, or OS if fsys is nil.
+func OrOS(fsys FS) FS {
+	if fsys == nil {
+		return OS
+	}
+	return fsys
+}
+
+// Writer returns the WriteFS the generated files are written to with fsys:
+// OS if fsys is nil. It returns an error if fsys is not a WriteFS, not to
+// write to the disk what is read from elsewhere.
+func Writer(fsys FS) (WriteFS, error) {
+	if fsys == nil {
+		return OS, nil
+	}
+	if w, ok := fsys.(WriteFS); ok {
+		return w, nil
+	}
+	return nil, fmt.Errorf("the generated files can't be written to %T, which is not a WriteFS, see NewOverlay", fsys)
+}
+
+/*
+Overlay is a WriteFS whose files replace or add to the ones of Base, by
+absolute path, like the overlay of go/packages. An editor generates from its
+unsaved buffers with the disk as Base, a test generates from memory only with
+a nil Base:
+
+	overlay := api.NewOverlay(nil, map[string][]byte{
+		"/src/go.mod":   []byte("module example.com/x\n"),
+		"/src/color.go": []byte("package x\n\n//go:generate ag\n\n// @Enum {red}\ntype Color int\n"),
+	})
+
+The generated files are written to Files, not to Base.
+*/
+type Overlay struct {
+	Base  FS
+	Files map[string][]byte
+}
+
+// NewOverlay returns an Overlay of files over base. The paths of files are
+// made absolute.
+func NewOverlay(base FS, files map[string][]byte) *Overlay {
+	o := &Overlay{Base: base, Files: map[string][]byte{}}
+	for name, content := range files {
+		o.Files[absPath(name)] = content
+	}
+	return o
+}
+
+func (o *Overlay) ReadFile(name string) ([]byte, error) {
+	if content, ok := o.Files[absPath(name)]; ok {
+		return content, nil
+	}
+	if o.Base == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return o.Base.ReadFile(name)
+}
+
+func (o *Overlay) Stat(name string) (fs.FileInfo, error) {
+	name = absPath(name)
+	if content, ok := o.Files[name]; ok {
+		return &overlayFileInfo{name: filepath.Base(name), size: int64(len(content))}, nil
+	}
+	// a directory of an overlay file
+	for file := range o.Files {
+		if strings.HasPrefix(file, name+string(filepath.Separator)) {
+			return &overlayFileInfo{name: filepath.Base(name), dir: true}, nil
+		}
+	}
+	if o.Base == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return o.Base.Stat(name)
+}
+
+func (o *Overlay) Glob(pattern string) ([]string, error) {
+	pattern = absPath(pattern)
+
+	var result []string
+	if o.Base != nil {
+		matches, err := o.Base.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, matches...)
+	}
+
+	for file := range o.Files {
+		matched, err := filepath.Match(pattern, file)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			result = append(result, file)
+		}
+	}
+
+	sort.Strings(result)
+	unique := result[:0]
+	for i, file := range result {
+		if i == 0 || file != result[i-1] {
+			unique = append(unique, file)
+		}
+	}
+	return unique, nil
+}
+
+func (o *Overlay) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	o.Files[absPath(name)] = append([]byte{}, data...)
+	return nil
+}
+
+// Remove removes an overlay file. The files of Base are left alone.
+func (o *Overlay) Remove(name string) error {
+	name = absPath(name)
+	if _, ok := o.Files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(o.Files, name)
+	return nil
+}
+
+type overlayFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi *overlayFileInfo) Name() string       { return fi.name }
+func (fi *overlayFileInfo) Size() int64        { return fi.size }
+func (fi *overlayFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *overlayFileInfo) IsDir() bool        { return fi.dir }
+func (fi *overlayFileInfo) Sys() any           { return nil }
+
+func (fi *overlayFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// MountFS returns an FS of the files of fsys, like an embed.FS or an
+// fstest.MapFS, at the absolute directory root.
+func MountFS(root string, fsys fs.FS) FS {
+	return &mountFS{root: absPath(root), fsys: fsys}
+}
+
+type mountFS struct {
+	root string
+	fsys fs.FS
+}
+
+// rel returns the io/fs name of name, or false if it is not under root.
+func (m *mountFS) rel(name string) (string, bool) {
+	rel, err := filepath.Rel(m.root, absPath(name))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func (m *mountFS) ReadFile(name string) ([]byte, error) {
+	rel, ok := m.rel(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return fs.ReadFile(m.fsys, rel)
+}
+
+func (m *mountFS) Stat(name string) (fs.FileInfo, error) {
+	rel, ok := m.rel(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return fs.Stat(m.fsys, rel)
+}
+
+func (m *mountFS) Glob(pattern string) ([]string, error) {
+	rel, ok := m.rel(pattern)
+	if !ok {
+		return nil, nil
+	}
+	matches, err := fs.Glob(m.fsys, rel)
+	if err != nil {
+		return nil, err
+	}
+	for i, match := range matches {
+		matches[i] = filepath.Join(m.root, filepath.FromSlash(match))
+	}
+	return matches, nil
+}
+
+func absPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return filepath.Clean(name)
+}
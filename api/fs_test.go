@@ -0,0 +1,73 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.go"), []byte("package disk\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.go"), []byte("package disk\n"), 0o644))
+
+	o := NewOverlay(OS, map[string][]byte{
+		filepath.Join(dir, "a.go"):        []byte("package overlay\n"),
+		filepath.Join(dir, "sub", "c.go"): []byte("package sub\n"),
+	})
+
+	content, err := o.ReadFile(filepath.Join(dir, "a.go"))
+	assert.NoError(t, err)
+	assert.Equal(t, "package overlay\n", string(content))
+	content, err = o.ReadFile(filepath.Join(dir, "b.go"))
+	assert.NoError(t, err)
+	assert.Equal(t, "package disk\n", string(content))
+
+	files, err := o.Glob(filepath.Join(dir, "*.go"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")}, files)
+
+	fi, err := o.Stat(filepath.Join(dir, "sub"))
+	if assert.NoError(t, err) {
+		assert.True(t, fi.IsDir())
+	}
+
+	assert.NoError(t, o.WriteFile(filepath.Join(dir, "a_ag.go"), []byte("package overlay\n"), 0o644))
+	_, err = os.Stat(filepath.Join(dir, "a_ag.go"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, o.Remove(filepath.Join(dir, "a_ag.go")))
+	assert.Error(t, o.Remove(filepath.Join(dir, "b.go")), "the files of Base are left alone")
+
+	memory := NewOverlay(nil, nil)
+	_, err = memory.ReadFile(filepath.Join(dir, "b.go"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMountFS(t *testing.T) {
+	fsys := MountFS("/src", fstest.MapFS{
+		"go.mod":   {Data: []byte("module example.com/x\n")},
+		"x/a.go":   {Data: []byte("package x\n")},
+		"x/a_test": {Data: []byte("")},
+	})
+
+	files, err := fsys.Glob("/src/x/*.go")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.FromSlash("/src/x/a.go")}, files)
+
+	_, err = fsys.ReadFile("/other/go.mod")
+	assert.True(t, os.IsNotExist(err))
+
+	fi, err := GetFileInfoFS(fsys, "/src/x/a.go")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "example.com/x", fi.ModuleName)
+		assert.Equal(t, "example.com/x/x", fi.FileFullPath)
+	}
+
+	_, err = Writer(fsys)
+	assert.Error(t, err, "a read only FS is not written to the disk")
+	w, err := Writer(NewOverlay(fsys, nil))
+	assert.NoError(t, err)
+	assert.NotEqual(t, OS, w)
+}
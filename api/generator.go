@@ -12,9 +12,29 @@ type Generator interface {
 	WriteBody(wr io.Writer) error
 }
 
+/*
+BaseGenerator writes the const, init and body templates of Tmpl. Each one is
+executed once per item of DataList, then the one named like it with a List
+suffix, like bodyList, is executed once with the whole DataList:
+
+	{{ define "constList" }}
+	var _ColorNames = []string{ {{- range . }}{{ quote .Name }}, {{ end -}} }
+	{{ end }}
+
+Tmpl is best created by NewTemplate, for the funcs of TemplateFuncs.
+*/
 type BaseGenerator[T any] struct {
 	Tmpl     *template.Template
 	DataList []*T
+	// Sections are the names of extra templates, written after the body in
+	// this order.
+	Sections []string
+}
+
+// NewTemplate returns a template with the funcs of TemplateFuncs, for the
+// templates of a BaseGenerator to be parsed into.
+func NewTemplate(name string, imports *Imports) *template.Template {
+	return template.New(name).Funcs(TemplateFuncs(imports))
 }
 
 func (bg *BaseGenerator[T]) GetImports() []string {
@@ -32,6 +52,12 @@ func (bg *BaseGenerator[T]) ExecuteTemplate(wr io.Writer, name string) error {
 		}
 	}
 
+	if listTmpl := bg.Tmpl.Lookup(name + "List"); listTmpl != nil {
+		if err := listTmpl.Execute(wr, bg.DataList); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -44,5 +70,15 @@ func (bg *BaseGenerator[T]) WriteInitFunc(wr io.Writer) error {
 }
 
 func (bg *BaseGenerator[T]) WriteBody(wr io.Writer) error {
-	return bg.ExecuteTemplate(wr, "body")
+	if err := bg.ExecuteTemplate(wr, "body"); err != nil {
+		return err
+	}
+
+	for _, section := range bg.Sections {
+		if err := bg.ExecuteTemplate(wr, section); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"go/token"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Import is an import of a generated file. Name is the name the package is
+// used with, "." for a dot import and "_" for a blank one.
+type Import struct {
+	Path string
+	Name string
+}
+
+// String returns the import spec, naming the package only when its name is
+// not the one guessed from its path.
+func (i *Import) String() string {
+	if i.Name == ImportName(i.Path) {
+		return strconv.Quote(i.Path)
+	}
+	return i.Name + " " + strconv.Quote(i.Path)
+}
+
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// ImportName returns the name a package is guessed to have from its path: the
+// last element of the path, skipping a vN major version, without a go- prefix
+// and what follows a dot or a dash, like yaml for gopkg.in/yaml.v3.
+func ImportName(importPath string) string {
+	if majorVersion.MatchString(path.Base(importPath)) && path.Dir(importPath) != "." {
+		importPath = path.Dir(importPath)
+	}
+
+	name := strings.TrimPrefix(path.Base(importPath), "go-")
+	if i := strings.IndexAny(name, ".-"); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+/*
+Imports are the imports of a generated file, shared by its generators. A
+generator asks for a package path and gets the name to use it with, which
+differs from the guessed one when that name is already used by another
+package, a declaration of the package or a generated symbol:
+
+	errorsName := imports.Add("errors")
+	yamlName := imports.AddAs("gopkg.in/yaml.v3", "yaml")
+*/
+type Imports struct {
+	byPath   map[string]*Import
+	byName   map[string]string // name -> path
+	reserved map[string]bool
+}
+
+// NewImports returns Imports which will not use the reserved names, like the
+// ones declared by the package.
+func NewImports(reserved ...string) *Imports {
+	im := &Imports{byPath: map[string]*Import{}, byName: map[string]string{}, reserved: map[string]bool{}}
+	im.Reserve(reserved...)
+	return im
+}
+
+// Reserve prevents the names from being used by the next imports.
+func (im *Imports) Reserve(names ...string) {
+	for _, name := range names {
+		im.reserved[name] = true
+	}
+}
+
+// Add imports importPath and returns the name to use it with.
+func (im *Imports) Add(importPath string) string {
+	return im.AddAs(importPath, "")
+}
+
+// AddAs imports importPath with name if it is free, or with name followed by
+// a number otherwise, and returns the name used. An empty name is the guessed
+// one, and a name that is no identifier, like a keyword, is pkg. A package
+// imported before keeps its name.
+func (im *Imports) AddAs(importPath string, name string) string {
+	if i, ok := im.byPath[importPath]; ok && i.Name != "." && i.Name != "_" {
+		return i.Name
+	}
+
+	if len(name) == 0 {
+		name = ImportName(importPath)
+	}
+	if !token.IsIdentifier(name) {
+		name = "pkg"
+	}
+
+	free := name
+	for n := 1; !im.isFree(free); n++ {
+		free = name + strconv.Itoa(n)
+	}
+
+	im.byPath[importPath] = &Import{Path: importPath, Name: free}
+	im.byName[free] = importPath
+	return free
+}
+
+// AddDot imports importPath with a dot import, unless it is imported with a
+// name already.
+func (im *Imports) AddDot(importPath string) {
+	if _, ok := im.byPath[importPath]; !ok {
+		im.byPath[importPath] = &Import{Path: importPath, Name: "."}
+	}
+}
+
+// AddBlank imports importPath for its side effects, unless it is imported
+// already.
+func (im *Imports) AddBlank(importPath string) {
+	if _, ok := im.byPath[importPath]; !ok {
+		im.byPath[importPath] = &Import{Path: importPath, Name: "_"}
+	}
+}
+
+// Name returns the name importPath is used with, if it is imported with one.
+func (im *Imports) Name(importPath string) (string, bool) {
+	i, ok := im.byPath[importPath]
+	if !ok || i.Name == "." || i.Name == "_" {
+		return "", false
+	}
+	return i.Name, true
+}
+
+// Used reports whether name is used, by the package imported with it, whose
+// path is returned, or as a reserved name, with an empty path.
+func (im *Imports) Used(name string) (string, bool) {
+	if importPath, ok := im.byName[name]; ok {
+		return importPath, true
+	}
+	return "", im.reserved[name]
+}
+
+// List returns the imports sorted by path.
+func (im *Imports) List() []*Import {
+	result := make([]*Import, 0, len(im.byPath))
+	for _, i := range im.byPath {
+		result = append(result, i)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+func (im *Imports) isFree(name string) bool {
+	if _, ok := im.byName[name]; ok {
+		return false
+	}
+	return !im.reserved[name] && token.IsIdentifier(name)
+}
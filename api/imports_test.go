@@ -0,0 +1,64 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestImportName(t *testing.T) {
+	assert.Equal(t, "errors", ImportName("errors"))
+	assert.Equal(t, "template", ImportName("text/template"))
+	assert.Equal(t, "yaml", ImportName("gopkg.in/yaml.v3"))
+	assert.Equal(t, "participle", ImportName("github.com/alecthomas/participle/v2"))
+	assert.Equal(t, "isatty", ImportName("github.com/mattn/go-isatty"))
+}
+
+func TestImports(t *testing.T) {
+	im := NewImports("errors", "Color")
+
+	assert.Equal(t, "errors1", im.Add("errors"))
+	assert.Equal(t, "errors1", im.Add("errors"))
+	assert.Equal(t, "errors2", im.Add("github.com/pkg/errors"))
+	assert.Equal(t, "fmt", im.Add("fmt"))
+	assert.Equal(t, "y", im.AddAs("gopkg.in/yaml.v3", "y"))
+	assert.Equal(t, "pkg", im.Add("example.com/go"))
+	assert.Equal(t, "pkg1", im.AddAs("example.com/types", "type"))
+
+	im.Reserve("strings")
+	assert.Equal(t, "strings1", im.Add("strings"))
+
+	im.AddDot("example.com/dsl")
+	im.AddBlank("embed")
+	im.AddBlank("fmt")
+
+	name, ok := im.Name("gopkg.in/yaml.v3")
+	assert.True(t, ok)
+	assert.Equal(t, "y", name)
+	_, ok = im.Name("example.com/dsl")
+	assert.False(t, ok)
+
+	importPath, ok := im.Used("errors2")
+	assert.True(t, ok)
+	assert.Equal(t, "github.com/pkg/errors", importPath)
+	importPath, ok = im.Used("Color")
+	assert.True(t, ok)
+	assert.Empty(t, importPath)
+	_, ok = im.Used("os")
+	assert.False(t, ok)
+
+	var specs []string
+	for _, i := range im.List() {
+		specs = append(specs, i.String())
+	}
+	assert.Equal(t, []string{
+		`_ "embed"`,
+		`errors1 "errors"`,
+		`. "example.com/dsl"`,
+		`pkg "example.com/go"`,
+		`pkg1 "example.com/types"`,
+		`"fmt"`,
+		`errors2 "github.com/pkg/errors"`,
+		`y "gopkg.in/yaml.v3"`,
+		`strings1 "strings"`,
+	}, specs)
+}
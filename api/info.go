@@ -1,21 +1,102 @@
 package api
 
 import (
-	"errors"
+	"fmt"
+	"go/build"
 	"golang.org/x/mod/modfile"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// FileInfo locates a go file in its module, and in the workspace of the
+// module when it is used by a go.work.
 type FileInfo struct {
-	ModuleName           string
-	ModuleAbsLocalPath   string
+	ModuleName         string
+	ModuleAbsLocalPath string
+	// Deprecated: FileFullPath is the module path joined with the directory of
+	// the file, use ImportPath.
 	FileFullPath         string
 	FileFullAbsLocalPath string
+	// ImportPath and PackageName are the ones of the package the file is
+	// generated in, set by the generator from go list, like
+	// example.com/x/color_test and color_test for the external test package
+	// ExternalTest tells. OutputFile is the absolute path of the generated
+	// file.
+	ImportPath   string
+	PackageName  string
+	OutputFile   string
+	ExternalTest bool
+	// WorkspaceAbsLocalPath is the directory of the go.work using the module,
+	// empty outside a workspace.
+	WorkspaceAbsLocalPath string
+	// Modules are the directories of the modules of the workspace by module
+	// path, or the directory of the module only outside a workspace.
+	Modules map[string]string
+	// VendorAbsLocalPath is the vendor directory of the workspace, or of the
+	// module outside a workspace, empty when the dependencies are not
+	// vendored.
+	VendorAbsLocalPath string
+	// GOPATHMode tells that the file is in no module but in the src directory
+	// of a GOPATH, ModuleAbsLocalPath then, and ModuleName is empty.
+	GOPATHMode bool
+
+	fsys     FS
+	vendored map[string]bool // the packages listed in vendor/modules.txt
+}
+
+// ModulePaths returns the paths of Modules, sorted.
+func (fi *FileInfo) ModulePaths() []string {
+	result := make([]string, 0, len(fi.Modules))
+	for modulePath := range fi.Modules {
+		result = append(result, modulePath)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ResolveImportPath returns the local directory of the package of importPath
+// when it is in a module of the workspace, the longest matching module path
+// winning for nested modules, or the directory it is vendored in when
+// vendor/modules.txt lists it. In GOPATH mode, it is the directory of
+// importPath in the GOPATH src directory, if there is one.
+func (fi *FileInfo) ResolveImportPath(importPath string) (string, bool) {
+	if fi.GOPATHMode {
+		dir := filepath.Join(fi.ModuleAbsLocalPath, filepath.FromSlash(importPath))
+		if info, err := OrOS(fi.fsys).Stat(dir); err != nil || !info.IsDir() {
+			return "", false
+		}
+		return dir, true
+	}
+
+	found := ""
+	for modulePath := range fi.Modules {
+		if (importPath == modulePath || strings.HasPrefix(importPath, modulePath+"/")) && len(modulePath) > len(found) {
+			found = modulePath
+		}
+	}
+	if len(found) > 0 {
+		rel := strings.TrimPrefix(strings.TrimPrefix(importPath, found), "/")
+		return filepath.Join(fi.Modules[found], filepath.FromSlash(rel)), true
+	}
+
+	if fi.vendored[importPath] {
+		return filepath.Join(fi.VendorAbsLocalPath, filepath.FromSlash(importPath)), true
+	}
+
+	return "", false
 }
 
 func GetFileInfo(filename string) (*FileInfo, error) {
+	return GetFileInfoFS(OS, filename)
+}
+
+// GetFileInfoFS is GetFileInfo reading go.mod and go.work from fsys. The
+// go.work is the one of GOWORK, or the first one found from the module
+// directory up, unless GOWORK is off. A go.work which does not use the module
+// is ignored.
+func GetFileInfoFS(fsys FS, filename string) (*FileInfo, error) {
 	inputFile, err := filepath.Abs(filename)
 	if err != nil {
 		return nil, err
@@ -23,32 +104,155 @@ func GetFileInfo(filename string) (*FileInfo, error) {
 
 	ret := &FileInfo{
 		FileFullAbsLocalPath: inputFile,
+		fsys:                 fsys,
 	}
 
-	// To find go.mod file
-	dirPath := filepath.Dir(inputFile)
-	for {
-		if _, err = os.Stat(filepath.Join(dirPath, "go.mod")); err == nil {
-			break
-		}
-		dirPath = filepath.Dir(dirPath)
-		if dirPath == "/" || dirPath == "." {
-			return nil, errors.New("go.mod not found")
-		}
+	dirPath, ok := findUp(fsys, filepath.Dir(inputFile), "go.mod")
+	if !ok {
+		return gopathFileInfo(ret)
 	}
 
-	goModData, err := os.ReadFile(filepath.Join(dirPath, "go.mod"))
+	ret.ModuleAbsLocalPath = dirPath
+	ret.ModuleName, err = modulePath(fsys, dirPath)
 	if err != nil {
 		return nil, err
 	}
+	ret.Modules = map[string]string{ret.ModuleName: dirPath}
 
-	ret.ModuleAbsLocalPath = dirPath
-	ret.ModuleName = modfile.ModulePath(goModData)
 	relativePath, err := filepath.Rel(dirPath, filepath.Dir(inputFile))
 	if err != nil {
 		return nil, err
 	}
 	ret.FileFullPath = strings.Replace(ret.ModuleName+"/"+relativePath, `\`, `/`, -1)
 
+	if err = ret.loadWorkspace(fsys); err != nil {
+		return nil, err
+	}
+
+	vendorDir := ret.ModuleAbsLocalPath
+	if len(ret.WorkspaceAbsLocalPath) > 0 {
+		vendorDir = ret.WorkspaceAbsLocalPath
+	}
+	if data, err := fsys.ReadFile(filepath.Join(vendorDir, "vendor", "modules.txt")); err == nil {
+		ret.VendorAbsLocalPath = filepath.Join(vendorDir, "vendor")
+		ret.vendored = vendoredPackages(data)
+	}
+
 	return ret, nil
 }
+
+// loadWorkspace sets the workspace of the module, if a go.work uses it.
+func (fi *FileInfo) loadWorkspace(fsys FS) error {
+	var workFile string
+	switch gowork := os.Getenv("GOWORK"); gowork {
+	case "off":
+		return nil
+	case "":
+		dir, ok := findUp(fsys, fi.ModuleAbsLocalPath, "go.work")
+		if !ok {
+			return nil
+		}
+		workFile = filepath.Join(dir, "go.work")
+	default:
+		workFile = absPath(gowork)
+	}
+
+	data, err := fsys.ReadFile(workFile)
+	if err != nil {
+		return err
+	}
+	work, err := modfile.ParseWork(workFile, data, nil)
+	if err != nil {
+		return err
+	}
+
+	workDir := filepath.Dir(workFile)
+	modules := map[string]string{}
+	used := false
+	for _, use := range work.Use {
+		dir := filepath.FromSlash(use.Path)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(workDir, dir)
+		}
+		modulePath, err := modulePath(fsys, dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", workFile, err)
+		}
+		modules[modulePath] = dir
+		used = used || dir == fi.ModuleAbsLocalPath
+	}
+
+	if used {
+		fi.WorkspaceAbsLocalPath = workDir
+		fi.Modules = modules
+	}
+	return nil
+}
+
+// gopathFileInfo completes ret, of a file in no module, in GOPATH mode.
+func gopathFileInfo(ret *FileInfo) (*FileInfo, error) {
+	gopath := os.Getenv("GOPATH")
+	if len(gopath) == 0 {
+		gopath = build.Default.GOPATH
+	}
+
+	dir := filepath.Dir(ret.FileFullAbsLocalPath)
+	for _, root := range filepath.SplitList(gopath) {
+		if len(root) == 0 {
+			continue
+		}
+		src := filepath.Join(root, "src")
+		rel, err := filepath.Rel(src, dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		ret.GOPATHMode = true
+		ret.ModuleAbsLocalPath = src
+		ret.FileFullPath = filepath.ToSlash(rel)
+		return ret, nil
+	}
+
+	return nil, fmt.Errorf("go.mod not found for %s", ret.FileFullAbsLocalPath)
+}
+
+// vendoredPackages returns the packages of a vendor/modules.txt, the lines
+// which are not comments of a module.
+func vendoredPackages(data []byte) map[string]bool {
+	result := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			result[line] = true
+		}
+	}
+	return result
+}
+
+// findUp returns the first directory from dir up to the root with a file
+// called name.
+func findUp(fsys FS, dir string, name string) (string, bool) {
+	for {
+		if _, err := fsys.Stat(filepath.Join(dir, name)); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// modulePath returns the module path of the go.mod of dir.
+func modulePath(fsys FS, dir string) (string, error) {
+	data, err := fsys.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	result := modfile.ModulePath(data)
+	if len(result) == 0 {
+		return "", fmt.Errorf("%s: no module path", filepath.Join(dir, "go.mod"))
+	}
+	return result, nil
+}
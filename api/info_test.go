@@ -2,6 +2,7 @@ package api
 
 import (
 	"github.com/stretchr/testify/assert"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,3 +15,85 @@ func TestGetMod(t *testing.T) {
 	assert.Equal(t, "github.com/expgo/ag", fi.ModuleName)
 	assert.Equal(t, "github.com/expgo/ag/api/a/b/c", fi.FileFullPath)
 }
+
+func TestGetFileInfoWorkspace(t *testing.T) {
+	t.Setenv("GOWORK", "")
+
+	root := filepath.FromSlash("/ws")
+	fsys := NewOverlay(nil, map[string][]byte{
+		"/ws/go.work":            []byte("go 1.20\n\nuse (\n\t./a\n\t./b\n\t./b/nested\n)\n"),
+		"/ws/a/go.mod":           []byte("module example.com/a\n"),
+		"/ws/a/x/f.go":           []byte("package x\n"),
+		"/ws/b/go.mod":           []byte("module example.com/b\n"),
+		"/ws/b/nested/go.mod":    []byte("module example.com/b/nested\n"),
+		"/ws/vendor/modules.txt": []byte("# example.com/bb v1.0.0\n## explicit; go 1.20\nexample.com/bb\n"),
+		"/ws/c/go.mod":           []byte("module example.com/c\n"),
+		"/ws/c/f.go":             []byte("package c\n"),
+	})
+
+	fi, err := GetFileInfoFS(fsys, "/ws/a/x/f.go")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "example.com/a", fi.ModuleName)
+	assert.Equal(t, filepath.Join(root, "a"), fi.ModuleAbsLocalPath)
+	assert.Equal(t, "example.com/a/x", fi.FileFullPath)
+	assert.Equal(t, root, fi.WorkspaceAbsLocalPath)
+	assert.Equal(t, filepath.Join(root, "vendor"), fi.VendorAbsLocalPath)
+	assert.Equal(t, []string{"example.com/a", "example.com/b", "example.com/b/nested"}, fi.ModulePaths())
+
+	for importPath, expected := range map[string]string{
+		"example.com/b":          filepath.Join(root, "b"),
+		"example.com/b/y":        filepath.Join(root, "b", "y"),
+		"example.com/b/nested/z": filepath.Join(root, "b", "nested", "z"),
+		"example.com/bb":         filepath.Join(root, "vendor", "example.com", "bb"),
+	} {
+		dir, ok := fi.ResolveImportPath(importPath)
+		assert.True(t, ok)
+		assert.Equal(t, expected, dir, importPath)
+	}
+	for _, importPath := range []string{"fmt", "example.com/bb/sub", "example.com/d"} {
+		_, ok := fi.ResolveImportPath(importPath)
+		assert.False(t, ok, importPath)
+	}
+
+	// a module the go.work does not use
+	fi, err = GetFileInfoFS(fsys, "/ws/c/f.go")
+	if assert.NoError(t, err) {
+		assert.Empty(t, fi.WorkspaceAbsLocalPath)
+		assert.Empty(t, fi.VendorAbsLocalPath)
+		assert.Equal(t, []string{"example.com/c"}, fi.ModulePaths())
+		_, ok := fi.ResolveImportPath("example.com/b")
+		assert.False(t, ok)
+	}
+
+	t.Setenv("GOWORK", "off")
+	fi, err = GetFileInfoFS(fsys, "/ws/a/x/f.go")
+	if assert.NoError(t, err) {
+		assert.Empty(t, fi.WorkspaceAbsLocalPath)
+	}
+}
+
+func TestGetFileInfoWithoutModule(t *testing.T) {
+	t.Setenv("GOPATH", filepath.FromSlash("/gopath"))
+
+	fsys := NewOverlay(nil, map[string][]byte{
+		"/gopath/src/example.com/x/f.go": []byte("package x\n"),
+		"/gopath/src/example.com/y/g.go": []byte("package y\n"),
+		"/elsewhere/f.go":                []byte("package x\n"),
+	})
+
+	fi, err := GetFileInfoFS(fsys, "/gopath/src/example.com/x/f.go")
+	if assert.NoError(t, err) {
+		assert.True(t, fi.GOPATHMode)
+		assert.Equal(t, "example.com/x", fi.FileFullPath)
+		dir, ok := fi.ResolveImportPath("example.com/y")
+		assert.True(t, ok)
+		assert.Equal(t, filepath.FromSlash("/gopath/src/example.com/y"), dir)
+		_, ok = fi.ResolveImportPath("fmt")
+		assert.False(t, ok)
+	}
+
+	_, err = GetFileInfoFS(fsys, "/elsewhere/f.go")
+	assert.ErrorContains(t, err, "go.mod not found")
+}
@@ -18,3 +18,11 @@ type Order int
 type IOrder interface {
 	Order() Order
 }
+
+// IDepend is an optional interface of GeneratorFactory, naming the package
+// paths of the plugins whose generators must run before its one, like
+// github.com/expgo/enum for a plugin using the Parse functions of the enums.
+// It takes precedence over IOrder, and plugins which are not used are ignored.
+type IDepend interface {
+	DependsOn() []string
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+	"sync"
+)
+
+//go:generate ag
+
+/*
+ContentType is the kind of content of an Output.
+
+	@Enum {
+		Go = "go"
+		JSON = "json"
+		YAML = "yaml"
+		SQL = "sql"
+		Proto = "proto"
+		Text = "text"
+	}
+*/
+type ContentType string
+
+// Output is a file written by a generator besides the generated file of the
+// source, like a test file, a file per type, a mock in another package, or a
+// non go file like an OpenAPI spec or a migration.
+type Output struct {
+	// Path of the file, relative to the directory of the source file, or to
+	// the module root if ModuleRoot is set.
+	Path       string
+	ModuleRoot bool
+	// ContentType of the file, go when empty.
+	ContentType ContentType
+	// Package of a go file, by default the package of the source file, or
+	// the name of the directory of Path when it is another one.
+	Package string
+	// Content of the file. A go file is given a header and its package clause
+	// by ag, and is formatted like the generated file. Another file is written
+	// as is, once passed to the OutputFormatter of its content type.
+	Content []byte
+}
+
+// IsGo reports whether the output is a go file.
+func (o *Output) IsGo() bool {
+	return len(o.ContentType) == 0 || o.ContentType == ContentTypeGo
+}
+
+// OutputGenerator is an optional interface of Generator, for the generators
+// writing other files. The go outputs of several generators with the same
+// Path are written to one file. A file already at the Path of an output is only
+// replaced, or removed once not generated anymore, when ag generated it: a go
+// file with the generated code notice of ag, another file with the content
+// hash recorded in the header of the generated file of the source.
+type OutputGenerator interface {
+	Outputs() ([]*Output, error)
+}
+
+// OutputFormatter formats and validates the content of a non go output.
+type OutputFormatter func(content []byte) ([]byte, error)
+
+var outputFormatters = struct {
+	sync.RWMutex
+	m map[ContentType]OutputFormatter
+}{m: map[ContentType]OutputFormatter{
+	ContentTypeJson: formatJSON,
+	ContentTypeYaml: validateYAML,
+}}
+
+// RegisterOutputFormatter sets the formatter of the outputs of a content type,
+// replacing the default one of JSON and YAML. A nil formatter removes it.
+func RegisterOutputFormatter(contentType ContentType, formatter OutputFormatter) {
+	outputFormatters.Lock()
+	defer outputFormatters.Unlock()
+
+	if formatter == nil {
+		delete(outputFormatters.m, contentType)
+	} else {
+		outputFormatters.m[contentType] = formatter
+	}
+}
+
+// GetOutputFormatter returns the formatter of the outputs of a content type,
+// if any.
+func GetOutputFormatter(contentType ContentType) OutputFormatter {
+	outputFormatters.RLock()
+	defer outputFormatters.RUnlock()
+
+	return outputFormatters.m[contentType]
+}
+
+func formatJSON(content []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := json.Indent(buf, content, "", "  "); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+func validateYAML(content []byte) ([]byte, error) {
+	var v any
+	if err := yaml.Unmarshal(content, &v); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
@@ -0,0 +1,69 @@
+// Code generated by https://github.com/expgo/ag DO NOT EDIT.
+// Plugins:
+//   - github.com/expgo/enum
+
+package api
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// ContentTypeGo is a ContentType of type Go.
+	ContentTypeGo ContentType = "go"
+	// ContentTypeJson is a ContentType of type JSON.
+	ContentTypeJson ContentType = "json"
+	// ContentTypeYaml is a ContentType of type YAML.
+	ContentTypeYaml ContentType = "yaml"
+	// ContentTypeSql is a ContentType of type SQL.
+	ContentTypeSql ContentType = "sql"
+	// ContentTypeProto is a ContentType of type Proto.
+	ContentTypeProto ContentType = "proto"
+	// ContentTypeText is a ContentType of type Text.
+	ContentTypeText ContentType = "text"
+)
+
+var ErrInvalidContentType = errors.New("not a valid ContentType")
+
+var _ContentTypeNameMap = map[string]ContentType{
+	"Go":    ContentTypeGo,
+	"JSON":  ContentTypeJson,
+	"YAML":  ContentTypeYaml,
+	"SQL":   ContentTypeSql,
+	"Proto": ContentTypeProto,
+	"Text":  ContentTypeText,
+}
+
+// Name is the attribute of ContentType.
+func (x ContentType) Name() string {
+	if v, ok := _ContentTypeNameMap[string(x)]; ok {
+		return string(v)
+	}
+	return fmt.Sprintf("ContentType(%s).Name", string(x))
+}
+
+// Val is the attribute of ContentType.
+func (x ContentType) Val() string {
+	return string(x)
+}
+
+// IsValid provides a quick way to determine if the typed value is
+// part of the allowed enumerated values
+func (x ContentType) IsValid() bool {
+	_, ok := _ContentTypeNameMap[string(x)]
+	return ok
+}
+
+// String implements the Stringer interface.
+func (x ContentType) String() string {
+	return x.Name()
+}
+
+// ParseContentType converts a string to a ContentType.
+func ParseContentType(value string) (ContentType, error) {
+	if x, ok := _ContentTypeNameMap[value]; ok {
+		return x, nil
+	}
+	return "", fmt.Errorf("%s is %w", value, ErrInvalidContentType)
+}
@@ -0,0 +1,56 @@
+package api
+
+import "sort"
+
+// SymbolTable holds the symbols, like ParseColor, the generators declared
+// they write, by plugin package path.
+type SymbolTable struct {
+	symbols map[string]string // symbol -> plugin
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{symbols: map[string]string{}}
+}
+
+// Declare declares the symbols written by plugin.
+func (st *SymbolTable) Declare(plugin string, symbols ...string) {
+	for _, s := range symbols {
+		st.symbols[s] = plugin
+	}
+}
+
+// Lookup returns the plugin which declared symbol.
+func (st *SymbolTable) Lookup(symbol string) (plugin string, ok bool) {
+	plugin, ok = st.symbols[symbol]
+	return
+}
+
+// Has reports whether symbol was declared.
+func (st *SymbolTable) Has(symbol string) bool {
+	_, ok := st.symbols[symbol]
+	return ok
+}
+
+// Symbols returns the sorted symbols declared by plugin.
+func (st *SymbolTable) Symbols(plugin string) []string {
+	var result []string
+	for s, p := range st.symbols {
+		if p == plugin {
+			result = append(result, s)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// SymbolProvider is an optional interface of Generator, declaring the symbols
+// it writes, for the generators running after it.
+type SymbolProvider interface {
+	Symbols() []string
+}
+
+// SymbolConsumer is an optional interface of Generator, given the symbols
+// declared by the generators running before it, before it writes anything.
+type SymbolConsumer interface {
+	UseSymbols(st *SymbolTable)
+}
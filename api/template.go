@@ -0,0 +1,97 @@
+package api
+
+import (
+	"github.com/iancoleman/strcase"
+	"go/ast"
+	"go/types"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// CommentWidth is the width the comment template func wraps comments at.
+const CommentWidth = 80
+
+/*
+TemplateFuncs returns the funcs shared by the templates of the generators:
+
+	camel, pascal, snake, kebab    Color Value -> colorValue, ColorValue, color_value, color-value
+	plural                         Color -> Colors, Category -> Categories
+	quote                          a Go string literal of its argument
+	indent                         indent n text, tabs before the non empty lines of text
+	comment                        text as // lines wrapped at CommentWidth
+	typeString                     the Go source of an ast.Expr, like []*pkg.Color
+	importName                     the name to use the package of a path with
+
+importName adds the package to imports, or only guesses its name when imports
+is nil.
+*/
+func TemplateFuncs(imports *Imports) template.FuncMap {
+	return template.FuncMap{
+		"camel":  strcase.ToLowerCamel,
+		"pascal": strcase.ToCamel,
+		"snake":  strcase.ToSnake,
+		"kebab":  strcase.ToKebab,
+		"plural": plural,
+		"quote":  strconv.Quote,
+		"indent": indent,
+		"comment": func(text string) string {
+			return comment(text, CommentWidth)
+		},
+		"typeString": func(expr ast.Expr) string {
+			return types.ExprString(expr)
+		},
+		"importName": func(importPath string) string {
+			if imports == nil {
+				return ImportName(importPath)
+			}
+			return imports.Add(importPath)
+		},
+	}
+}
+
+func plural(word string) string {
+	lower := strings.ToLower(word)
+	switch {
+	case len(word) == 0:
+		return word
+	case strings.HasSuffix(lower, "y") && len(word) > 1 && !strings.ContainsAny(lower[len(lower)-2:len(lower)-1], "aeiou"):
+		return word[:len(word)-1] + "ies"
+	case strings.HasSuffix(lower, "s"), strings.HasSuffix(lower, "x"), strings.HasSuffix(lower, "z"),
+		strings.HasSuffix(lower, "ch"), strings.HasSuffix(lower, "sh"):
+		return word + "es"
+	}
+	return word + "s"
+}
+
+func indent(n int, text string) string {
+	prefix := strings.Repeat("\t", n)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if len(strings.TrimSpace(line)) > 0 {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// comment returns text as // comment lines no longer than width, unless a
+// word is. Paragraphs are kept.
+func comment(text string, width int) string {
+	var sb strings.Builder
+	for i, paragraph := range strings.Split(strings.TrimSpace(text), "\n\n") {
+		if i > 0 {
+			sb.WriteString("//\n")
+		}
+		line := "//"
+		for _, word := range strings.Fields(paragraph) {
+			if len(line) > 2 && len(line)+1+len(word) > width {
+				sb.WriteString(line + "\n")
+				line = "//"
+			}
+			line += " " + word
+		}
+		sb.WriteString(line + "\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"go/parser"
+	"testing"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	imports := NewImports("errors")
+	expr, err := parser.ParseExpr("map[string][]*yaml.Node")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tmpl, err := NewTemplate("test", imports).Parse(`{{ camel .Name }} {{ pascal .Name }} {{ snake .Name }} {{ kebab .Name }}
+{{ plural "Color" }} {{ plural "Category" }} {{ plural "Box" }} {{ plural "Key" }}
+{{ quote .Name }} {{ typeString .Type }} {{ importName "errors" }} {{ importName "fmt" }}
+{{ indent 1 "a\n\nb" }}
+{{ comment .Doc }}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, map[string]any{
+		"Name": "color value",
+		"Type": expr,
+		"Doc":  "Color is a color, which is so long a text that it is wrapped on two lines of comments.\n\nIt is an enum.",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, `colorValue ColorValue color_value color-value
+Colors Categories Boxes Keys
+"color value" map[string][]*yaml.Node errors1 fmt
+	a
+
+	b
+// Color is a color, which is so long a text that it is wrapped on two lines of
+// comments.
+//
+// It is an enum.`, buf.String())
+	assert.Len(t, imports.List(), 2)
+}
+
+type testItem struct {
+	Name string
+}
+
+func TestBaseGeneratorTemplates(t *testing.T) {
+	tmpl, err := NewTemplate("test", nil).Parse(`
+{{- define "const" }}const {{ .Name }} = {{ quote .Name }}
+{{ end }}
+{{- define "constList" }}var names = []string{ {{- range $i, $item := . }}{{ if $i }}, {{ end }}{{ .Name }}{{ end -}} }
+{{ end }}
+{{- define "body" }}// body {{ .Name }}
+{{ end }}
+{{- define "test" }}// test {{ .Name }}
+{{ end }}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	bg := &BaseGenerator[testItem]{Tmpl: tmpl, DataList: []*testItem{{"a"}, {"b"}}, Sections: []string{"test"}}
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, bg.WriteConst(buf))
+	assert.NoError(t, bg.WriteInitFunc(buf))
+	assert.NoError(t, bg.WriteBody(buf))
+
+	assert.Equal(t, `const a = "a"
+const b = "b"
+var names = []string{a, b}
+// body a
+// body b
+// test a
+// test b
+`, buf.String())
+}
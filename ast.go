@@ -1,8 +1,11 @@
 package ag
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/expgo/ag/api"
+	"github.com/expgo/structure"
 	"go/ast"
 	"go/parser"
 	"go/token"
@@ -10,28 +13,234 @@ import (
 	"strings"
 )
 
-func parseFile(inputFile string) (*ast.File, *token.FileSet, error) {
+// source is a parsed go file, used to report annotation positions and errors
+// against the real file.
+type source struct {
+	filename string
+	fileSet  *token.FileSet
+	content  []byte
+	detect   api.DetectMode
+}
+
+func parseFile(fsys api.FS, inputFile string) (*ast.File, *source, error) {
+	content, err := fsys.ReadFile(inputFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate: error reading input file '%s': %s", inputFile, err)
+	}
+
+	return parseSource(inputFile, content)
+}
+
+func parseSource(inputFile string, content []byte) (*ast.File, *source, error) {
 	fileSet := token.NewFileSet()
-	fileNode, err := parser.ParseFile(fileSet, inputFile, nil, parser.ParseComments)
+	fileNode, err := parser.ParseFile(fileSet, inputFile, content, parser.ParseComments)
 	if err != nil {
 		return nil, nil, fmt.Errorf("generate: error parsing input file '%s': %s", inputFile, err)
 	}
 
-	return fileNode, fileSet, nil
+	return fileNode, &source{filename: inputFile, fileSet: fileSet, content: content}, nil
+}
+
+// textOrigin is where a text returned by annotationText starts in the file:
+// its byte offset, and the number of lines before it.
+type textOrigin struct {
+	offset int
+	lines  int
+}
+
+// position returns pos, a position in the text, as a position in the file.
+func (o textOrigin) position(pos lexer.Position) lexer.Position {
+	if pos.Line > 0 {
+		pos.Offset += o.offset
+		pos.Line += o.lines
+	}
+	return pos
+}
+
+// shift moves the positions of annotations, parsed from the text, to the file.
+func (o textOrigin) shift(annotations *api.Annotations) {
+	for _, a := range annotations.Annotations {
+		o.shiftAnnotation(a)
+	}
+}
+
+func (o textOrigin) shiftAnnotation(a *api.Annotation) {
+	a.Pos = o.position(a.Pos)
+	for _, p := range a.Params {
+		p.Pos = o.position(p.Pos)
+		o.shiftValue(p.Value)
+	}
+	for _, e := range a.Extends {
+		e.Pos = o.position(e.Pos)
+		o.shiftValue(e.Value)
+		for _, v := range e.Values {
+			o.shiftValue(v)
+		}
+	}
+}
+
+// shiftValue shifts the annotations used as values within v.
+func (o textOrigin) shiftValue(v structure.ValueWrapper) {
+	switch v := v.(type) {
+	case api.AnnotationValue:
+		if v.V != nil {
+			o.shiftAnnotation(v.V)
+		}
+	case api.Slice:
+		for _, item := range v.V {
+			o.shiftValue(item)
+		}
+	case api.Map:
+		for _, entry := range v.V {
+			o.shiftValue(entry.Value)
+		}
+	}
+}
+
+// annotationText returns the content of the comment group from the start of
+// its first line, with everything else blanked out, so that the columns found
+// by the annotation parser are the columns in the file, along with the origin
+// of the text in the file. What the detect mode does not take as annotations
+// is blanked out as well. Only the lines of the group are copied, whatever the
+// size of the file.
+func (s *source) annotationText(cg *ast.CommentGroup) (string, textOrigin) {
+	start := s.fileSet.Position(cg.Pos())
+	origin := textOrigin{offset: start.Offset - (start.Column - 1), lines: start.Line - 1}
+
+	text := blank(s.content[origin.offset:s.fileSet.Position(cg.End()).Offset])
+
+	for _, c := range cg.List {
+		marker := s.detect == api.DetectModeMarker && strings.HasPrefix(c.Text, "//ag:")
+		if strings.HasPrefix(c.Text, "//") && isDirective(c.Text[2:]) && !marker {
+			continue
+		}
+
+		offset := s.fileSet.Position(c.Pos()).Offset - origin.offset
+		copy(text[offset:], c.Text)
+
+		// blank out the comment markers
+		if marker {
+			copy(text[offset:], " "+markerPrefix)
+		} else {
+			copy(text[offset:], "  ")
+		}
+		if strings.HasPrefix(c.Text, "/*") {
+			copy(text[offset+len(c.Text)-2:], "  ")
+		}
+	}
+
+	detectText(s.detect, text)
+
+	return string(text), origin
+}
+
+// fileText returns text, returned by annotationText, with the content of the
+// file before it blanked out, for the offsets in the text to be the ones in
+// the file.
+func (s *source) fileText(text string, origin textOrigin) string {
+	return string(blank(s.content[:origin.offset])) + text
+}
+
+// originSource returns the lines of the file text spans, from its origin.
+func (s *source) originSource(text string, origin textOrigin) string {
+	end := origin.offset + len(text)
+	if i := bytes.IndexByte(s.content[end:], '\n'); i >= 0 {
+		end += i
+	} else {
+		end = len(s.content)
+	}
+	return string(s.content[origin.offset:end])
+}
+
+// blank returns a copy of content with everything but line breaks replaced
+// by spaces.
+func blank(content []byte) []byte {
+	result := make([]byte, len(content))
+	for i, c := range content {
+		if c == '\n' {
+			result[i] = '\n'
+		} else {
+			result[i] = ' '
+		}
+	}
+	return result
+}
+
+// isDirective reports whether c, a line comment without its //, is a
+// directive like go:generate, which ast.CommentGroup.Text drops as well.
+func isDirective(c string) bool {
+	if strings.HasPrefix(c, "line ") {
+		return true
+	}
+
+	colon := strings.Index(c, ":")
+	if colon <= 0 || colon+1 >= len(c) {
+		return false
+	}
+	for i := 0; i <= colon+1; i++ {
+		if i == colon {
+			continue
+		}
+		b := c[i]
+		if !('a' <= b && b <= 'z' || '0' <= b && b <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// getAnnotations parses the annotations of the comment group. Malformed
+// annotations are skipped and their errors returned with the valid ones.
+func (s *source) getAnnotations(names []string, cg *ast.CommentGroup) (*api.Annotations, []error) {
+	if cg == nil {
+		return nil, nil
+	}
+
+	prefix := "@"
+	if s.detect == api.DetectModeMarker {
+		prefix = "ag:"
+	}
+
+	var comments strings.Builder
+	for _, c := range cg.List {
+		comments.WriteString(c.Text)
+		comments.WriteByte('\n')
+	}
+
+	if !containsAnnotation(comments.String(), prefix, names) {
+		return nil, nil
+	}
+
+	// the names may only have been seen in prose, or behind an escape
+	text, origin := s.annotationText(cg)
+	if !containsAnnotation(text[s.fileSet.Position(cg.Pos()).Offset-origin.offset:], "@", names) {
+		return nil, nil
+	}
+
+	annotations, errs := parseAnnotationRecover(s.filename, text, s.originSource(text, origin), origin)
+	if len(annotations.Annotations) == 0 {
+		return nil, errs
+	}
+	return annotations, errs
 }
 
-func getAnnotations(parseName string, names []string, comments string) (*api.Annotations, error) {
-	lowComments := strings.ToLower(comments)
+// containsAnnotation reports whether text holds one of names behind prefix.
+// Names are case-sensitive.
+func containsAnnotation(text string, prefix string, names []string) bool {
 	for _, name := range names {
-		if strings.Contains(lowComments, "@"+strings.ToLower(name)) {
-			ag, err := ParseAnnotation(parseName, comments)
-			if err != nil {
-				return nil, fmt.Errorf("parse annotation err: %v", err)
-			}
-			return ag, nil
+		if strings.Contains(text, prefix+name) {
+			return true
 		}
 	}
-	return nil, nil
+	return false
+}
+
+// docOrComment returns the doc comment group if any, or the line comment one.
+func docOrComment(doc *ast.CommentGroup, comment *ast.CommentGroup) *ast.CommentGroup {
+	if doc != nil {
+		return doc
+	}
+	return comment
 }
 
 func getRecvType(fd *ast.FuncDecl) *ast.TypeSpec {
@@ -59,30 +268,20 @@ func getRecvType(fd *ast.FuncDecl) *ast.TypeSpec {
 	return nil
 }
 
-func inspectFile(fileNode *ast.File, fileSet *token.FileSet, typeMaps map[api.AnnotationType][]string, fileInfo *api.FileInfo) (result []*api.TypedAnnotation, e error) {
+func inspectFile(fileNode *ast.File, src *source, typeMaps map[api.AnnotationType][]string, fileInfo *api.FileInfo) (result []*api.TypedAnnotation, parseErrs []error) {
 	ast.Inspect(fileNode, func(n ast.Node) bool {
 		switch decl := n.(type) {
 		case *ast.TypeSpec:
 			if names, ok := typeMaps[api.AnnotationTypeType]; ok {
 				if decl.Doc == nil {
-					decl.Doc = FindDocLocationCommentGroup(fileNode, fileSet, decl.Pos())
+					decl.Doc = FindDocLocationCommentGroup(fileNode, src.fileSet, decl.Pos())
 				}
 				if decl.Comment == nil {
-					decl.Comment = FindCommentLocationCommentGroup(fileNode, fileSet, decl.Pos())
-				}
-
-				var comment string
-				if decl.Doc != nil {
-					comment = decl.Doc.Text()
-				} else if decl.Comment != nil {
-					comment = decl.Comment.Text()
+					decl.Comment = FindCommentLocationCommentGroup(fileNode, src.fileSet, decl.Pos())
 				}
 
-				annotations, err := getAnnotations(decl.Name.Name, names, comment)
-				if err != nil {
-					e = err
-					return false
-				}
+				annotations, errs := src.getAnnotations(names, docOrComment(decl.Doc, decl.Comment))
+				parseErrs = append(parseErrs, errs...)
 
 				if annotations != nil {
 					result = append(result, &api.TypedAnnotation{api.AnnotationTypeType, decl, annotations, nil, fileInfo})
@@ -90,20 +289,14 @@ func inspectFile(fileNode *ast.File, fileSet *token.FileSet, typeMaps map[api.An
 			}
 		case *ast.FuncDecl:
 			if decl.Doc == nil {
-				decl.Doc = FindDocLocationCommentGroup(fileNode, fileSet, decl.Pos())
-			}
-
-			var comment string
-			if decl.Doc != nil {
-				comment = decl.Doc.Text()
+				decl.Doc = FindDocLocationCommentGroup(fileNode, src.fileSet, decl.Pos())
 			}
 
 			var annotations *api.Annotations
 			if names, ok := typeMaps[api.AnnotationTypeFunc]; ok {
-				annotations, e = getAnnotations(decl.Name.Name, names, comment)
-				if e != nil {
-					return false
-				}
+				var errs []error
+				annotations, errs = src.getAnnotations(names, decl.Doc)
+				parseErrs = append(parseErrs, errs...)
 			}
 			funcAnnotation := &api.TypedAnnotation{api.AnnotationTypeFunc, decl, annotations, nil, fileInfo}
 
@@ -112,24 +305,14 @@ func inspectFile(fileNode *ast.File, fileSet *token.FileSet, typeMaps map[api.An
 					recvType := getRecvType(decl)
 					if recvType != nil {
 						if recvType.Doc == nil {
-							recvType.Doc = FindDocLocationCommentGroup(fileNode, fileSet, recvType.Pos())
+							recvType.Doc = FindDocLocationCommentGroup(fileNode, src.fileSet, recvType.Pos())
 						}
 						if recvType.Comment == nil {
-							recvType.Comment = FindCommentLocationCommentGroup(fileNode, fileSet, recvType.Pos())
-						}
-
-						comment = ""
-						if recvType.Doc != nil {
-							comment = recvType.Doc.Text()
-						} else if recvType.Comment != nil {
-							comment = recvType.Comment.Text()
+							recvType.Comment = FindCommentLocationCommentGroup(fileNode, src.fileSet, recvType.Pos())
 						}
 
-						recvAnnotations, err := getAnnotations(recvType.Name.Name, names, comment)
-						if err != nil {
-							e = err
-							return false
-						}
+						recvAnnotations, errs := src.getAnnotations(names, docOrComment(recvType.Doc, recvType.Comment))
+						parseErrs = append(parseErrs, errs...)
 						if recvAnnotations != nil {
 							result = append(result, &api.TypedAnnotation{api.AnnotationTypeFuncRecv, recvType, recvAnnotations, funcAnnotation, fileInfo})
 						}
@@ -140,24 +323,14 @@ func inspectFile(fileNode *ast.File, fileSet *token.FileSet, typeMaps map[api.An
 			if names, ok := typeMaps[api.AnnotationTypeFuncField]; ok {
 				for _, field := range decl.Type.Params.List {
 					if field.Doc == nil {
-						field.Doc = FindDocLocationCommentGroup(fileNode, fileSet, field.Pos())
+						field.Doc = FindDocLocationCommentGroup(fileNode, src.fileSet, field.Pos())
 					}
 					if field.Comment == nil {
-						field.Comment = FindCommentLocationCommentGroup(fileNode, fileSet, field.Pos())
-					}
-
-					comment = ""
-					if field.Doc != nil {
-						comment = field.Doc.Text()
-					} else if field.Comment != nil {
-						comment = field.Comment.Text()
+						field.Comment = FindCommentLocationCommentGroup(fileNode, src.fileSet, field.Pos())
 					}
 
-					fieldAnnotations, err := getAnnotations(field.Names[0].Name, names, comment)
-					if err != nil {
-						e = err
-						return false
-					}
+					fieldAnnotations, errs := src.getAnnotations(names, docOrComment(field.Doc, field.Comment))
+					parseErrs = append(parseErrs, errs...)
 					if fieldAnnotations != nil {
 						result = append(result, &api.TypedAnnotation{api.AnnotationTypeFuncField, field, fieldAnnotations, funcAnnotation, fileInfo})
 					}
@@ -175,29 +348,43 @@ func inspectFile(fileNode *ast.File, fileSet *token.FileSet, typeMaps map[api.An
 	return
 }
 
+// ParseFile returns the typed annotations of a go file. A malformed annotation
+// does not stop parsing: the valid annotations are returned along with a
+// ParseErrors holding every annotation error of the file.
 func ParseFile(filename string, typeMaps map[api.AnnotationType][]string) (result []*api.TypedAnnotation, packageName string, e error) {
-	fileInfo, err := api.GetFileInfo(filename)
+	return ParseFileFS(api.OS, filename, typeMaps)
+}
+
+// ParseFileFS is ParseFile reading the file, go.mod and ag.yaml from fsys.
+func ParseFileFS(fsys api.FS, filename string, typeMaps map[api.AnnotationType][]string) (result []*api.TypedAnnotation, packageName string, e error) {
+	fileInfo, err := api.GetFileInfoFS(fsys, filename)
 	if err != nil {
 		return nil, "", err
 	}
 
 	filename, _ = filepath.Abs(filename)
 
-	fileNode, fileSet, err := parseFile(filename)
+	config, err := api.LoadConfigFS(fsys, fileInfo.ModuleAbsLocalPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	fileNode, src, err := parseFile(fsys, filename)
 	if err != nil {
 		return nil, "", err
 	}
+	src.detect = config.Detect
 
 	packageName = fileNode.Name.Name
 
+	var parseErrs []error
+
 	// global TypedAnnotation
 	if names, ok := typeMaps[api.AnnotationTypeGlobal]; ok {
 		for _, cg := range fileNode.Comments {
 			if strings.HasPrefix(cg.List[len(cg.List)-1].Text, "//go:generate") {
-				annotations, err := getAnnotations(fileNode.Name.Name, names, cg.Text())
-				if err != nil {
-					return nil, "", err
-				}
+				annotations, errs := src.getAnnotations(names, cg)
+				parseErrs = append(parseErrs, errs...)
 				if annotations != nil {
 					result = append(result, &api.TypedAnnotation{api.AnnotationTypeGlobal, fileNode, annotations, nil, fileInfo})
 				}
@@ -206,12 +393,14 @@ func ParseFile(filename string, typeMaps map[api.AnnotationType][]string) (resul
 	}
 
 	// other TypedAnnotation
-	ta, err := inspectFile(fileNode, fileSet, typeMaps, fileInfo)
-	if err != nil {
-		return nil, "", err
-	}
+	ta, errs := inspectFile(fileNode, src, typeMaps, fileInfo)
+	parseErrs = append(parseErrs, errs...)
 	result = append(result, ta...)
 
+	if len(parseErrs) > 0 {
+		e = ParseErrors(parseErrs)
+	}
+
 	return
 }
 
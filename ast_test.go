@@ -1,9 +1,15 @@
 package ag
 
 import (
+	"errors"
+	"github.com/expgo/ag/api"
+	"github.com/stretchr/testify/assert"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -123,3 +129,190 @@ func abc(){}
 	//	return nil
 	//})
 }
+
+func TestParseFileError(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, "color.go")
+	src := "package x\n\n// Color is a color.\n//\n//\t@Enum {\n//\t\tRed 1\n//\t}\ntype Color int\n"
+	if err := os.WriteFile(filename, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := ParseFile(filename, map[api.AnnotationType][]string{api.AnnotationTypeType: {"Enum"}})
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("ParseFile() error = %v, want a ParseError", err)
+	}
+
+	assert.Equal(t, filename, parseErr.Pos.Filename)
+	assert.Equal(t, 6, parseErr.Pos.Line)
+	assert.Equal(t, 9, parseErr.Pos.Column)
+	assert.Equal(t, "//\t\tRed 1", parseErr.Source)
+	assert.Equal(t, `missing "=" between "Red" and its value`, parseErr.Hint)
+	assert.Contains(t, err.Error(), "color.go:6:9: ")
+	assert.Contains(t, err.Error(), "//\t\tRed 1\n\t  \t\t    ^")
+}
+
+func TestParseFilePositions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, "color.go")
+	src := `package x
+
+// Color is a color.
+type Color int
+
+// Size is a size.
+//
+//	@Route(middleware = {@Auth(role = admin)}) {
+//		Get
+//	}
+type Size int
+
+// @Enum(a = 1
+type Shape int
+`
+	if err := os.WriteFile(filename, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, _, err := ParseFile(filename, map[api.AnnotationType][]string{api.AnnotationTypeType: {"Route", "Enum"}})
+
+	var parseErrs ParseErrors
+	if assert.True(t, errors.As(err, &parseErrs)) && assert.Len(t, parseErrs, 1) {
+		var parseErr *ParseError
+		if assert.True(t, errors.As(parseErrs[0], &parseErr)) {
+			assert.Equal(t, 13, parseErr.Pos.Line)
+			assert.Equal(t, "// @Enum(a = 1", parseErr.Source)
+			assert.Equal(t, `"(" opened at 13:9 is never closed`, parseErr.Hint)
+		}
+	}
+
+	if !assert.Len(t, result, 1) {
+		return
+	}
+	a := result[0].Annotations.Annotations[0]
+	assert.Equal(t, 8, a.Pos.Line)
+	assert.Equal(t, 5, a.Pos.Column)
+	assert.Equal(t, strings.Index(src, "Route"), a.Pos.Offset)
+
+	middleware := a.Params[0].Value.(api.Slice).V[0].(api.AnnotationValue).V
+	assert.Equal(t, 8, middleware.Pos.Line)
+	assert.Equal(t, strings.Index(src, "Auth"), middleware.Pos.Offset)
+	assert.Equal(t, 9, a.Extends[0].Pos.Line)
+	assert.Equal(t, strings.Index(src, "Get"), a.Extends[0].Pos.Offset)
+}
+
+func TestParseFileKeepsValidAnnotations(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, "color.go")
+	src := `package x
+
+// @Enum(a = 1
+type Color int
+
+// @Enum {
+//	Small
+// }
+type Size int
+
+// @Enum { Red 1 }
+type Shape int
+`
+	if err := os.WriteFile(filename, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, _, err := ParseFile(filename, map[api.AnnotationType][]string{api.AnnotationTypeType: {"Enum"}})
+
+	var parseErrs ParseErrors
+	if !errors.As(err, &parseErrs) {
+		t.Fatalf("ParseFile() error = %v, want ParseErrors", err)
+	}
+	assert.Len(t, parseErrs, 2)
+
+	if assert.Len(t, result, 1) {
+		assert.Equal(t, "Size", result[0].Node.(*ast.TypeSpec).Name.Name)
+	}
+}
+
+func TestParseFileDetect(t *testing.T) {
+	src := `package x
+
+// Color is mailed to ops@enum-team.com, see @@Enum for the syntax.
+type Color int
+
+// Size is like the @Enum of Color.
+//
+// @Enum {
+//	Small
+//	Large
+// }
+type Size int
+
+//ag:Enum { Red }
+type Shape int
+
+// Weight has a marker too.
+// +ag:Enum(
+//	prefix = "W"
+// )
+type Weight int
+`
+
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{"default", "", []string{"Size"}},
+		// loose takes the @Enum of the prose too
+		{"loose", "detect: loose\n", []string{"Size", "Size"}},
+		{"lineStart", "detect: lineStart\n", []string{"Size"}},
+		{"marker", "detect: marker\n", []string{"Shape", "Weight"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/x\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.config) > 0 {
+				if err := os.WriteFile(filepath.Join(dir, api.ConfigFileName), []byte(tt.config), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			filename := filepath.Join(dir, "x.go")
+			if err := os.WriteFile(filename, []byte(src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			result, _, err := ParseFile(filename, map[api.AnnotationType][]string{api.AnnotationTypeType: {"Enum"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, ta := range result {
+				for range ta.Annotations.Annotations {
+					got = append(got, ta.Node.(*ast.TypeSpec).Name.Name)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
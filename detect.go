@@ -0,0 +1,127 @@
+package ag
+
+import (
+	"bytes"
+	"github.com/expgo/ag/api"
+)
+
+// markerPrefix starts an annotation line in marker mode. annotationText
+// rewrites the //ag: marker to it, so both markers are handled alike.
+const markerPrefix = "+ag:"
+
+// detectText blanks whatever the mode does not take as annotations from text,
+// a comment group prepared by annotationText. In every mode the @@ escape and
+// an @ inside a word, like in a mail address, are blanked. In the line modes,
+// so is every line outside of an annotation, and in loose mode every such line
+// without an @, for the prose not to be lexed.
+func detectText(mode api.DetectMode, text []byte) {
+	blankInlineAts(text)
+
+	depth := 0
+	inComment := false
+	continued := false
+
+	for start := 0; start < len(text); {
+		end := bytes.IndexByte(text[start:], '\n')
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += start
+		}
+		line := text[start:end]
+		start = end + 1
+
+		keep := depth > 0 || inComment
+		if !keep {
+			p := len(line) - len(bytes.TrimLeft(line, " \t"))
+			switch {
+			case mode == api.DetectModeMarker && bytes.HasPrefix(line[p:], []byte(markerPrefix)):
+				copy(line[p:], "   @")
+				keep = true
+			case mode == api.DetectModeLineStart && p < len(line) && line[p] == '@':
+				keep = true
+			case mode == api.DetectModeLoose && bytes.IndexByte(line, '@') >= 0:
+				keep = true
+			case continued && p < len(line) && (line[p] == '{' || line[p] == '('):
+				// the params or extends of the annotation on the line above
+				keep = true
+			}
+		}
+
+		if !keep {
+			// blank lines do not break an annotation from its params
+			continued = continued && len(bytes.TrimSpace(line)) == 0
+			for i := range line {
+				line[i] = ' '
+			}
+			continue
+		}
+
+		depth, inComment = bracketDepth(line, depth, inComment)
+		continued = depth == 0 && !inComment
+	}
+}
+
+// blankInlineAts blanks the @@ escapes and the @ following a letter or a
+// digit, outside of strings.
+func blankInlineAts(text []byte) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\n':
+			quote = 0
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '`':
+			quote = c
+		case c == '@' && i+1 < len(text) && text[i+1] == '@':
+			text[i], text[i+1] = ' ', ' '
+			i++
+		case c == '@' && i > 0 && isIdentChar(text[i-1]):
+			text[i] = ' '
+		}
+	}
+}
+
+// bracketDepth returns the bracket depth and the block comment state at the
+// end of line, starting from depth and inComment. Like unclosedBrackets,
+// strings do not span lines.
+func bracketDepth(line []byte, depth int, inComment bool) (int, bool) {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case inComment:
+			if bytes.HasPrefix(line[i:], []byte("*/")) {
+				inComment = false
+				i++
+			}
+		case c == '"' || c == '`':
+			for i+1 < len(line) && line[i+1] != c {
+				if c == '"' && line[i+1] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+		case bytes.HasPrefix(line[i:], []byte("//")):
+			return depth, inComment
+		case bytes.HasPrefix(line[i:], []byte("/*")):
+			inComment = true
+			i++
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+
+	return depth, inComment
+}
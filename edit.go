@@ -0,0 +1,363 @@
+package ag
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/expgo/ag/api"
+	"github.com/expgo/structure"
+	"go/ast"
+	"go/token"
+	"os"
+	"sort"
+	"strings"
+)
+
+// TextEdit replaces the bytes of a source from Start to End, which are byte
+// offsets, with NewText. An insertion has Start equal to End.
+type TextEdit struct {
+	Start   int
+	End     int
+	NewText string
+}
+
+// ApplyEdits returns content with edits applied. The edits are against
+// content and must not overlap, insertions at the same offset are applied in
+// order.
+func ApplyEdits(content []byte, edits []TextEdit) ([]byte, error) {
+	sorted := append([]TextEdit{}, edits...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+
+	result := make([]byte, 0, len(content))
+	last := 0
+	for _, e := range sorted {
+		if e.Start < last || e.End < e.Start || e.End > len(content) {
+			return nil, fmt.Errorf("edit %d:%d overlaps another edit or is out of the source", e.Start, e.End)
+		}
+		result = append(result, content[last:e.Start]...)
+		result = append(result, e.NewText...)
+		last = e.End
+	}
+
+	return append(result, content[last:]...), nil
+}
+
+/*
+Editor edits the annotations of a go source as text edits, touching nothing
+else of it. Declarations are named like in the source, with methods written
+Recv.Method:
+
+	editor, _ := ag.NewEditor("color.go", content)
+	_ = editor.AddAnnotation("Color", &api.Annotation{Name: "Enum"})
+	_ = editor.RenameParam("Size", "Enum", "prefix", "trimPrefix")
+	result, _ := editor.Bytes()
+
+Every edit is computed against the original source, so an annotation added by
+the editor can not be edited by it again.
+*/
+type Editor struct {
+	src      *source
+	fileNode *ast.File
+	edits    []TextEdit
+}
+
+// NewEditor returns an Editor of the go source content.
+func NewEditor(filename string, content []byte) (*Editor, error) {
+	fileNode, src, err := parseSource(filename, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Editor{src: src, fileNode: fileNode}, nil
+}
+
+// OpenEditor returns an Editor of a go file.
+func OpenEditor(filename string) (*Editor, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEditor(filename, content)
+}
+
+// Edits returns the edits made so far.
+func (e *Editor) Edits() []TextEdit {
+	return e.edits
+}
+
+// Bytes returns the source with the edits applied.
+func (e *Editor) Bytes() ([]byte, error) {
+	return ApplyEdits(e.src.content, e.edits)
+}
+
+// AddAnnotation adds a to the doc comment of decl, as its last lines. A doc
+// comment is created if decl has none.
+func (e *Editor) AddAnnotation(decl string, a *api.Annotation) error {
+	doc, declPos, err := e.declaration(decl)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(a.Format(), "\n")
+
+	if doc == nil {
+		start := e.lineStart(e.offset(declPos))
+		lead := e.indent(start) + "//"
+		text := ""
+		for _, line := range lines {
+			text += continuation(lead, line) + "\n"
+		}
+		e.edits = append(e.edits, TextEdit{Start: start, End: start, NewText: text})
+		return nil
+	}
+
+	last := doc.List[len(doc.List)-1]
+	end := e.offset(last.End())
+
+	// within a block comment ending on a line of its own
+	if strings.HasPrefix(last.Text, "/*") && strings.Contains(last.Text, "\n") {
+		closing := e.lineStart(end - 2)
+		if len(strings.TrimSpace(string(e.src.content[closing:end-2]))) == 0 {
+			indent := e.indent(closing) + "\t"
+			text := ""
+			for _, line := range lines {
+				text += indent + line + "\n"
+			}
+			e.edits = append(e.edits, TextEdit{Start: closing, End: closing, NewText: text})
+			return nil
+		}
+	}
+
+	lead := e.indent(e.lineStart(e.offset(last.Pos()))) + "//"
+	text := ""
+	for _, line := range lines {
+		text += "\n" + continuation(lead, line)
+	}
+	e.edits = append(e.edits, TextEdit{Start: end, End: end, NewText: text})
+	return nil
+}
+
+// SetParam sets the param key of the annotation name of decl to value,
+// adding the param if the annotation has none with this key.
+func (e *Editor) SetParam(decl string, name string, key string, value structure.ValueWrapper) error {
+	a, text, err := e.annotation(decl, name)
+	if err != nil {
+		return err
+	}
+
+	param := key + " = " + api.FormatValue(value)
+
+	if a.Params == nil {
+		end := a.Name.Pos.Offset + len(a.Name.Text)
+		e.edits = append(e.edits, TextEdit{Start: end, End: end, NewText: "(" + param + ")"})
+		return nil
+	}
+
+	for _, p := range a.Params.List {
+		if p.Key != nil && p.Key.Text == key {
+			e.edits = append(e.edits, TextEdit{Start: p.start(), End: p.end(text), NewText: param})
+			return nil
+		}
+	}
+
+	closing := a.Params.ClosedParenthesis.Pos
+	if len(a.Params.List) == 0 {
+		e.edits = append(e.edits, TextEdit{Start: closing.Offset, End: closing.Offset, NewText: param})
+		return nil
+	}
+
+	last := a.Params.List[len(a.Params.List)-1]
+	lastEnd := last.end(text)
+	comma := strings.HasPrefix(strings.TrimLeft(text[lastEnd:], " \t"), ",")
+
+	if closing.Line == last.line() {
+		if comma {
+			lastEnd = strings.Index(text[lastEnd:], ",") + lastEnd + 1
+			param = " " + param
+		} else {
+			param = ", " + param
+		}
+		e.edits = append(e.edits, TextEdit{Start: lastEnd, End: lastEnd, NewText: param})
+		return nil
+	}
+
+	// one param per line
+	if !comma {
+		e.edits = append(e.edits, TextEdit{Start: lastEnd, End: lastEnd, NewText: ","})
+	}
+	lineStart := e.lineStart(closing.Offset)
+	lead := string(e.src.content[e.lineStart(last.start()):last.start()])
+	e.edits = append(e.edits, TextEdit{Start: lineStart, End: lineStart, NewText: lead + param + ",\n"})
+	return nil
+}
+
+// RenameParam renames the param oldKey of the annotation name of decl.
+func (e *Editor) RenameParam(decl string, name string, oldKey string, newKey string) error {
+	a, _, err := e.annotation(decl, name)
+	if err != nil {
+		return err
+	}
+
+	if a.Params != nil {
+		for _, p := range a.Params.List {
+			if p.Key != nil && p.Key.Text == oldKey {
+				e.edits = append(e.edits, TextEdit{Start: p.Key.Pos.Offset, End: p.Key.Pos.Offset + len(oldKey), NewText: newKey})
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%s: annotation @%s of %s has no param %s", e.src.filename, name, decl, oldKey)
+}
+
+// AddExtend appends extend to the extends of the annotation name of decl,
+// after the last one. The extends are not realigned, ag fmt does it.
+func (e *Editor) AddExtend(decl string, name string, extend *api.AnnotationExtend) error {
+	a, _, err := e.annotation(decl, name)
+	if err != nil {
+		return err
+	}
+
+	item := extend.Format()
+	annotationLead := string(e.src.content[e.lineStart(a.Name.Pos.Offset-1) : a.Name.Pos.Offset-1])
+
+	if a.Extends == nil {
+		end := a.Name.Pos.Offset + len(a.Name.Text)
+		if a.Params != nil {
+			end = a.Params.ClosedParenthesis.Pos.Offset + 1
+		}
+		text := " {\n" + continuation(annotationLead, "\t"+item) + "\n" + continuation(annotationLead, "}")
+		e.edits = append(e.edits, TextEdit{Start: end, End: end, NewText: text})
+		return nil
+	}
+
+	closing := a.Extends.ClosedBracket.Pos
+	if closing.Line == a.Extends.Pos.Line {
+		// a comment would hide the rest of the line
+		inline := *extend
+		inline.Comment = ""
+		item = inline.Format()
+
+		text := strings.TrimRight(string(e.src.content[:closing.Offset]), " \t")
+		switch {
+		case strings.HasSuffix(text, "{"), strings.HasSuffix(text, ","):
+			item = " " + item
+		default:
+			item = ", " + item
+		}
+		e.edits = append(e.edits, TextEdit{Start: len(text), End: len(text), NewText: item})
+		return nil
+	}
+
+	lead := continuation(annotationLead, "\t")
+	if n := len(a.Extends.List); n > 0 {
+		last := a.Extends.List[n-1].Name.Pos.Offset
+		lead = string(e.src.content[e.lineStart(last):last])
+	}
+	lineStart := e.lineStart(closing.Offset)
+	e.edits = append(e.edits, TextEdit{Start: lineStart, End: lineStart, NewText: lead + item + "\n"})
+	return nil
+}
+
+// declaration returns the doc comment of the type or func named decl, and
+// the position its line starts with.
+func (e *Editor) declaration(decl string) (*ast.CommentGroup, token.Pos, error) {
+	for _, d := range e.fileNode.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Name.Name != decl {
+					continue
+				}
+				if d.Lparen.IsValid() {
+					return ts.Doc, ts.Pos(), nil
+				}
+				return d.Doc, d.Pos(), nil
+			}
+		case *ast.FuncDecl:
+			name := d.Name.Name
+			if recv := recvTypeName(d); len(recv) > 0 {
+				name = recv + "." + name
+			}
+			if name == decl {
+				return d.Doc, d.Pos(), nil
+			}
+		}
+	}
+
+	return nil, token.NoPos, fmt.Errorf("%s: declaration %s not found", e.src.filename, decl)
+}
+
+// recvTypeName returns the type name of the receiver of fd, if fd is a method.
+func recvTypeName(fd *ast.FuncDecl) string {
+	if fd.Recv == nil || fd.Recv.NumFields() != 1 {
+		return ""
+	}
+
+	expr := fd.Recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch t := expr.(type) {
+	case *ast.IndexExpr:
+		expr = t.X
+	case *ast.IndexListExpr:
+		expr = t.X
+	}
+
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// annotation returns the annotation name in the doc comment of decl, along
+// with the text it was parsed from.
+func (e *Editor) annotation(decl string, name string) (*Annotation, string, error) {
+	doc, _, err := e.declaration(decl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if doc != nil {
+		// the edits are made at the offsets of the file
+		text := e.src.fileText(e.src.annotationText(doc))
+		annotations, err := annotationParser.ParseString(e.src.filename, text)
+		if err != nil {
+			return nil, "", newParseError(err, text, string(e.src.content), textOrigin{})
+		}
+		moveComments(annotations)
+
+		for _, a := range annotations.Annotations {
+			if a.Name.Text == name {
+				return a, text, nil
+			}
+		}
+	}
+
+	return nil, "", fmt.Errorf("%s: declaration %s has no annotation @%s", e.src.filename, decl, name)
+}
+
+func (e *Editor) offset(pos token.Pos) int {
+	return e.src.fileSet.Position(pos).Offset
+}
+
+func (e *Editor) lineStart(offset int) int {
+	return bytes.LastIndexByte(e.src.content[:offset], '\n') + 1
+}
+
+// indent returns the spaces and tabs starting the line at lineStart.
+func (e *Editor) indent(lineStart int) string {
+	line := e.src.content[lineStart:]
+	i := 0
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	return string(line[:i])
+}
@@ -0,0 +1,99 @@
+package ag
+
+import (
+	"github.com/expgo/ag/api"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestEditor(t *testing.T) {
+	src := `package x
+
+// Color is a color.
+type Color int
+
+/*
+	@Enum(prefix = "C") {
+		Red = 1
+		Green = 2
+	}
+*/
+type Size int
+
+type (
+	// @Enum {A, B}
+	Shape int
+)
+
+// @Route(
+//	path = "/x", // the path
+//	method = "GET"
+// )
+func (s *Size) Get() {}
+`
+
+	want := `package x
+
+// Color is a color.
+// @Enum {
+//	Red
+// }
+type Color int
+
+/*
+	@Enum(trimPrefix = "C", size = 2) {
+		Red = 1
+		Green = 2
+		Blue = 3 // the blue
+	}
+*/
+type Size int
+
+type (
+	// @Enum {A, B, C}
+	Shape int
+)
+
+// @Route(
+//	path = "/y", // the path
+//	method = "GET",
+//	auth = true,
+// )
+func (s *Size) Get() {}
+`
+
+	editor, err := NewEditor("x.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []error{
+		editor.AddAnnotation("Color", &api.Annotation{Name: "Enum", Extends: []*api.AnnotationExtend{{Name: "Red"}}}),
+		editor.RenameParam("Size", "Enum", "prefix", "trimPrefix"),
+		editor.SetParam("Size", "Enum", "size", api.Int{V: 2}),
+		editor.AddExtend("Size", "Enum", &api.AnnotationExtend{Name: "Blue", Value: api.Int{V: 3}, Comment: "// the blue"}),
+		editor.AddExtend("Shape", "Enum", &api.AnnotationExtend{Name: "C"}),
+		editor.SetParam("Size.Get", "Route", "path", api.String{V: "/y"}),
+		editor.SetParam("Size.Get", "Route", "auth", api.Bool{V: true}),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	got, err := editor.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(got)); len(diff) > 0 {
+		t.Errorf("Editor mismatch (-want +got):\n%s", diff)
+	}
+
+	if err = editor.RenameParam("Size", "Enum", "missing", "x"); err == nil {
+		t.Error("RenameParam() of a missing param should fail")
+	}
+	if err = editor.AddAnnotation("Missing", &api.Annotation{Name: "Enum"}); err == nil {
+		t.Error("AddAnnotation() of a missing declaration should fail")
+	}
+}
@@ -0,0 +1,232 @@
+package ag
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+	"strings"
+)
+
+// ParseError is an annotation parse error, pointing to the source line that
+// contains it, with a hint about the likely mistake when one is recognized.
+type ParseError struct {
+	Pos    lexer.Position
+	Msg    string
+	Source string // the offending source line
+	Hint   string
+}
+
+func (e *ParseError) Error() string {
+	sb := &strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s: %s", e.Pos, e.Msg))
+
+	if len(e.Source) > 0 && e.Pos.Column > 0 {
+		sb.WriteString("\n\t")
+		sb.WriteString(e.Source)
+		sb.WriteString("\n\t")
+		sb.WriteString(caretIndent(e.Source, e.Pos.Column))
+		sb.WriteString("^")
+	}
+
+	if len(e.Hint) > 0 {
+		sb.WriteString("\n\thint: ")
+		sb.WriteString(e.Hint)
+	}
+
+	return sb.String()
+}
+
+// ParseErrors holds every annotation error found while parsing.
+type ParseErrors []error
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e ParseErrors) Unwrap() []error {
+	return e
+}
+
+// caretIndent returns the whitespace that puts a caret under the given
+// column, keeping the tabs of the source line so the caret stays aligned.
+func caretIndent(line string, column int) string {
+	sb := &strings.Builder{}
+	for _, r := range []rune(line) {
+		if sb.Len() >= column-1 {
+			break
+		}
+		if r == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	return sb.String()
+}
+
+// newParseError converts an error of the annotation parser into a ParseError.
+// text is the parsed text and source the content it was masked from, both
+// sharing the same offsets, and origin where they start in the file.
+func newParseError(err error, text string, source string, origin textOrigin) error {
+	var perr participle.Error
+	if !errors.As(err, &perr) {
+		return err
+	}
+
+	result := &ParseError{
+		Pos: perr.Position(),
+		Msg: perr.Message(),
+	}
+
+	line := sourceLine(source, result.Pos)
+	result.Source = strings.TrimRight(line, "\r")
+
+	switch {
+	case strings.Contains(result.Msg, "literal not terminated"):
+		if column := unterminatedQuoteColumn(line); column > 0 {
+			result.Pos.Offset -= result.Pos.Column - column
+			result.Pos.Column = column
+		}
+		result.Msg = "unterminated string"
+		result.Hint = "add the closing quote, strings can not span lines"
+	default:
+		if opener, pos, ok := unclosedBracket(text); ok {
+			pos = origin.position(pos)
+			result.Hint = fmt.Sprintf("%q opened at %d:%d is never closed", opener, pos.Line, pos.Column)
+		} else if key, ok := missingAssign(text, result.Pos); ok {
+			result.Hint = fmt.Sprintf("missing \"=\" between %q and its value", key)
+		}
+	}
+
+	result.Pos = origin.position(result.Pos)
+	return result
+}
+
+// sourceLine returns the line of source at pos, if any.
+func sourceLine(source string, pos lexer.Position) string {
+	if pos.Offset < 0 || pos.Offset > len(source) {
+		return ""
+	}
+
+	start := strings.LastIndexByte(source[:pos.Offset], '\n') + 1
+	end := strings.IndexByte(source[pos.Offset:], '\n')
+	if end < 0 {
+		return source[start:]
+	}
+	return source[start : pos.Offset+end]
+}
+
+// unterminatedQuoteColumn returns the column of the quote opening the string
+// left unterminated on line, or 0.
+func unterminatedQuoteColumn(line string) int {
+	column := 0
+	for i := 0; i < len(line); i++ {
+		switch {
+		case column > 0 && line[i] == '\\':
+			i++
+		case line[i] == '"' && column > 0:
+			column = 0
+		case line[i] == '"':
+			column = i + 1
+		}
+	}
+	return column
+}
+
+type opener struct {
+	char byte
+	pos  lexer.Position
+}
+
+// unclosedBracket returns the innermost "(" or "{" of text that is never
+// closed.
+func unclosedBracket(text string) (string, lexer.Position, bool) {
+	stack := unclosedBrackets(text)
+	if len(stack) == 0 {
+		return "", lexer.Position{}, false
+	}
+
+	top := stack[len(stack)-1]
+	return string(top.char), top.pos, true
+}
+
+// unclosedBrackets returns the "(" and "{" of text that are never closed,
+// outermost first. Brackets inside strings and comments are skipped.
+func unclosedBrackets(text string) []opener {
+	var stack []opener
+	pos := lexer.Position{Line: 1, Column: 1}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case c == '"' || c == '`':
+			for i+1 < len(text) && text[i+1] != c && text[i+1] != '\n' {
+				if c == '"' && text[i+1] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+		case strings.HasPrefix(text[i:], "//"):
+			for i+1 < len(text) && text[i+1] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(text[i:], "/*"):
+			for i+1 < len(text) && !strings.HasPrefix(text[i+1:], "*/") {
+				i++
+				if text[i] == '\n' {
+					pos.Line++
+					pos.Offset = i + 1
+				}
+			}
+			i += 2
+		case c == '(' || c == '{':
+			stack = append(stack, opener{char: c, pos: lexer.Position{Offset: i, Line: pos.Line, Column: i - pos.Offset + 1}})
+		case c == ')' || c == '}':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case c == '\n':
+			pos.Line++
+			pos.Offset = i + 1
+		}
+	}
+
+	return stack
+}
+
+// missingAssign reports whether the token at pos is a value directly
+// following an identifier, as in { A 1 }, and returns that identifier.
+func missingAssign(text string, pos lexer.Position) (string, bool) {
+	if pos.Offset <= 0 || pos.Offset >= len(text) {
+		return "", false
+	}
+
+	c := text[pos.Offset]
+	if !(c == '"' || c == '`' || c == '-' || (c >= '0' && c <= '9')) {
+		return "", false
+	}
+
+	before := strings.TrimRight(text[:pos.Offset], " \t")
+	end := len(before)
+	start := end
+	for start > 0 && isIdentChar(before[start-1]) {
+		start--
+	}
+
+	if start == end || (before[start] >= '0' && before[start] <= '9') {
+		return "", false
+	}
+
+	return before[start:end], true
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
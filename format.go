@@ -0,0 +1,171 @@
+package ag
+
+import (
+	"github.com/expgo/ag/api"
+	"os"
+	"strings"
+)
+
+// FormatSource normalizes the annotations in the comments of a go source, as
+// printed by api.Annotation.Format. Only the annotations starting and ending
+// their lines are rewritten, anything else in the source is kept as is. A
+// malformed annotation leaves the source unchanged and is returned in a
+// ParseErrors, unless it only stands within prose.
+func FormatSource(filename string, content []byte) ([]byte, error) {
+	return formatSource(filename, content, api.DetectModeLineStart)
+}
+
+// FormatFile returns the content of a go file with its annotations formatted
+// like FormatSource does, using the detect mode of its module.
+func FormatFile(filename string) ([]byte, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := api.DetectModeLineStart
+	if fileInfo, err := api.GetFileInfo(filename); err == nil {
+		config, err := api.LoadConfig(fileInfo.ModuleAbsLocalPath)
+		if err != nil {
+			return nil, err
+		}
+		mode = config.Detect
+	}
+
+	return formatSource(filename, content, mode)
+}
+
+func formatSource(filename string, content []byte, mode api.DetectMode) ([]byte, error) {
+	fileNode, src, err := parseSource(filename, content)
+	if err != nil {
+		return nil, err
+	}
+	src.detect = mode
+
+	var edits []TextEdit
+	var errs []error
+
+	for _, cg := range fileNode.Comments {
+		text, origin := src.annotationText(cg)
+		if !strings.Contains(text[src.fileSet.Position(cg.Pos()).Offset-origin.offset:], "@") {
+			continue
+		}
+
+		annotations, err := annotationParser.ParseString(filename, text)
+		if err != nil {
+			// an @ within prose is no annotation to format
+			if startsAnnotationLine(text) {
+				errs = append(errs, newParseError(err, text, src.originSource(text, origin), origin))
+			}
+			continue
+		}
+		moveComments(annotations)
+
+		for _, a := range annotations.Annotations {
+			if e, ok := formatEdit(text, content[origin.offset:], a); ok {
+				e.Start += origin.offset
+				e.End += origin.offset
+				edits = append(edits, e)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, ParseErrors(errs)
+	}
+
+	return ApplyEdits(content, edits)
+}
+
+// startsAnnotationLine reports whether a line of text starts with an @ and a
+// name.
+func startsAnnotationLine(text string) bool {
+	for i := strings.IndexByte(text, '@'); i >= 0 && i+1 < len(text); {
+		if startsLine([]byte(text), i) && isIdentChar(text[i+1]) {
+			return true
+		}
+		next := strings.IndexByte(text[i+1:], '@')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return false
+}
+
+// formatEdit returns the edit formatting the annotation a, found in text as
+// prepared by annotationText from content, which has the same offsets. The
+// first line keeps what precedes the @ in content, the other lines get the
+// indentation and comment marker of the first.
+func formatEdit(text string, content []byte, a *Annotation) (TextEdit, bool) {
+	at := a.Name.Pos.Offset - 1
+	if at < 0 || text[at] != '@' {
+		return TextEdit{}, false
+	}
+
+	lineStart := strings.LastIndexByte(text[:at], '\n') + 1
+	if len(strings.TrimSpace(text[lineStart:at])) > 0 {
+		return TextEdit{}, false
+	}
+
+	end := a.end()
+	lineEnd := strings.IndexByte(text[end:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(text)
+	} else {
+		lineEnd += end
+	}
+	if len(strings.TrimSpace(text[end:lineEnd])) > 0 {
+		return TextEdit{}, false
+	}
+
+	formatted := a.toApi()
+	formatted.Doc = nil
+	if !a.trailingComment() {
+		formatted.Comment = ""
+	}
+
+	lead := string(content[lineStart:at])
+	commentAt := strings.Index(lead, "//")
+
+	var lines []string
+	if commentAt >= 0 && len(strings.TrimSpace(lead[:commentAt])) > 0 {
+		// a comment following code stays on its line
+		line, ok := formatted.FormatInline()
+		if !ok {
+			return TextEdit{}, false
+		}
+		lines = []string{line}
+	} else {
+		lines = strings.Split(formatted.Format(), "\n")
+	}
+
+	// keep the @ of the file, or the marker standing for it
+	lines[0] = lead + string(content[at]) + lines[0][1:]
+
+	for i := 1; i < len(lines); i++ {
+		lines[i] = continuation(lead, lines[i])
+	}
+
+	result := strings.Join(lines, "\n")
+	if result == string(content[lineStart:end]) {
+		return TextEdit{}, false
+	}
+
+	return TextEdit{Start: lineStart, End: end, NewText: result}, true
+}
+
+// continuation returns line prefixed like the lines following lead, the start
+// of a line in a comment: with the indentation of lead, and its // marker when
+// lead is in a line comment.
+func continuation(lead string, line string) string {
+	indent := lead[:len(lead)-len(strings.TrimLeft(lead, " \t"))]
+	switch {
+	case !strings.Contains(lead, "//"):
+		return indent + line
+	case strings.HasPrefix(line, "\t"):
+		return indent + "//" + line
+	default:
+		return indent + "// " + line
+	}
+}
@@ -0,0 +1,172 @@
+package ag
+
+import (
+	"github.com/expgo/ag/api"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestFormatSource(t *testing.T) {
+	src := `package x
+
+/*
+	Color is a color.
+
+	@EnumConfig(marshal,noCase, prefix=` + "`x`" + `)
+	@Enum {
+		Red=1 // the red
+		Green   = 2
+		// doc of blue
+		Blue( "b" ,3)=30
+		White
+	}
+*/
+type Color int
+
+// Size is like the @Enum(prefix="y") of Color, the @ of
+// prose is left alone.
+type Size int
+
+// @Route(
+//   path="/x", // the path
+//   auth = @Auth(role=admin) ,
+//    timeout = 1h30m, ratio=1.0, tags={a: 1, "b c": 2},
+// )
+func X() {}
+
+type T int // @Enum {A,B} // end
+`
+
+	want := `package x
+
+/*
+	Color is a color.
+
+	@EnumConfig(marshal, noCase, prefix = "x")
+	@Enum {
+		Red          = 1 // the red
+		Green        = 2
+		// doc of blue
+		Blue("b", 3) = 30
+		White
+	}
+*/
+type Color int
+
+// Size is like the @Enum(prefix="y") of Color, the @ of
+// prose is left alone.
+type Size int
+
+// @Route(
+//	path = "/x", // the path
+//	auth = @Auth(role = admin),
+//	timeout = 1h30m,
+//	ratio = 1.0,
+//	tags = {a = 1, "b c" = 2},
+// )
+func X() {}
+
+type T int // @Enum {A, B} // end
+`
+
+	got, err := FormatSource("x.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(got)); len(diff) > 0 {
+		t.Errorf("FormatSource() mismatch (-want +got):\n%s", diff)
+	}
+
+	again, err := FormatSource("x.go", got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), string(again)); len(diff) > 0 {
+		t.Errorf("FormatSource() is not stable (-first +second):\n%s", diff)
+	}
+}
+
+func TestAnnotationsFormat(t *testing.T) {
+	text := `
+@Route(path = "/users/{id}", "GET", required, timeout = -5s, ratio = 1e-06, empty = nil,
+	middleware = {@Auth(role = admin), @RateLimit(limit = 10) {Burst = 20}})
+@Enum(prefix = "C") {
+	// doc of Red
+	Red(1, "r") = 1.5 // red
+	Green
+	Blue        = time.Second
+}
+`
+
+	annotations, err := ParseAnnotation("x.go", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formatted, err := ParseAnnotation("x.go", annotations.Format())
+	if err != nil {
+		t.Fatalf("ParseAnnotation() of\n%s\nerror = %v", annotations.Format(), err)
+	}
+
+	opt := cmp.FilterPath(ignorePosFields, cmp.Ignore())
+	if diff := cmp.Diff(annotations, formatted, opt); len(diff) > 0 {
+		t.Errorf("Format() does not round-trip (-parsed +formatted):\n%s", diff)
+	}
+}
+
+func TestFormatSourceLiterals(t *testing.T) {
+	src := `package x
+
+// @Perm(mode=0o644, mask=0xFF, wait=90s, ratio=1.50, big=18446744073709551615, n=1_000, offset=-0x10)
+type File int
+`
+
+	want := `package x
+
+// @Perm(mode = 0o644, mask = 0xFF, wait = 90s, ratio = 1.50, big = 18446744073709551615, n = 1_000, offset = -0x10)
+type File int
+`
+
+	got, err := FormatSource("x.go", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(got)); len(diff) > 0 {
+		t.Errorf("FormatSource() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFormatSourceProse(t *testing.T) {
+	src := `package x
+
+// Color is the "main" color, it's like the @Enum of Size.
+//
+// @Enum {A,B}
+//
+// Don't add a value, it's stable.
+type Color int
+`
+
+	want := `package x
+
+// Color is the "main" color, it's like the @Enum of Size.
+//
+// @Enum {
+//	A
+//	B
+// }
+//
+// Don't add a value, it's stable.
+type Color int
+`
+
+	for _, mode := range []api.DetectMode{api.DetectModeLoose, api.DetectModeLineStart} {
+		got, err := formatSource("x.go", []byte(src), mode)
+		if err != nil {
+			t.Fatalf("formatSource() in %s mode error = %v", mode, err)
+		}
+		if diff := cmp.Diff(want, string(got)); len(diff) > 0 {
+			t.Errorf("formatSource() in %s mode mismatch (-want +got):\n%s", mode, diff)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// printDecls prints decls to buf with go/printer. go/printer places comments
+// by position, which built declarations don't have, so decls are printed
+// without their comments, parsed back, and their comments are attached to the
+// parsed nodes.
+func printDecls(buf *bytes.Buffer, decls []ast.Decl) error {
+	if len(decls) == 0 {
+		return nil
+	}
+
+	type comments struct {
+		doc  *ast.CommentGroup
+		line *ast.CommentGroup
+	}
+
+	// the comments of each node, in inspection order, detached while printing
+	var nodes []comments
+	var restore []func()
+	defer func() {
+		for _, r := range restore {
+			r()
+		}
+	}()
+
+	const header = "package p\n\n"
+	src := bytes.NewBufferString(header)
+	for _, decl := range decls {
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if n == nil {
+				return false
+			}
+			var c comments
+			if doc, line := commentFields(n); doc != nil {
+				c.doc, c.line = *doc, *line
+				*doc, *line = nil, nil
+				restore = append(restore, func() { *doc, *line = c.doc, c.line })
+			}
+			nodes = append(nodes, c)
+			return true
+		})
+
+		if err := printer.Fprint(src, token.NewFileSet(), decl); err != nil {
+			return err
+		}
+		src.WriteString("\n\n")
+	}
+
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "", src.Bytes(), 0)
+	if err != nil {
+		return sourceError(err, src.Bytes())
+	}
+
+	i := 0
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n.(type) {
+		case nil, *ast.CommentGroup:
+			// the comments just attached
+			return false
+		}
+		if n == file || n == file.Name {
+			return true
+		}
+		if i < len(nodes) {
+			if doc, line := commentFields(n); doc != nil {
+				*doc = positioned(nodes[i].doc, n.Pos()-1)
+				*line = positioned(nodes[i].line, n.End())
+				for _, cg := range []*ast.CommentGroup{*doc, *line} {
+					if cg != nil {
+						file.Comments = append(file.Comments, cg)
+					}
+				}
+			}
+		}
+		i++
+		return true
+	})
+	if i != len(nodes) {
+		return fmt.Errorf("generate: printing declarations: %d nodes parsed back instead of %d", i, len(nodes))
+	}
+
+	sort.Slice(file.Comments, func(i, j int) bool {
+		return file.Comments[i].Pos() < file.Comments[j].Pos()
+	})
+
+	out := &bytes.Buffer{}
+	if err = printer.Fprint(out, fileSet, file); err != nil {
+		return err
+	}
+	buf.Write(bytes.TrimPrefix(out.Bytes(), []byte(header)))
+	buf.WriteString("\n\n")
+	return nil
+}
+
+// commentFields returns the addresses of the doc and line comment fields of
+// n, or nil if n has none.
+func commentFields(n ast.Node) (doc **ast.CommentGroup, line **ast.CommentGroup) {
+	var none *ast.CommentGroup
+	switch n := n.(type) {
+	case *ast.GenDecl:
+		return &n.Doc, &none
+	case *ast.FuncDecl:
+		return &n.Doc, &none
+	case *ast.ValueSpec:
+		return &n.Doc, &n.Comment
+	case *ast.TypeSpec:
+		return &n.Doc, &n.Comment
+	case *ast.ImportSpec:
+		return &n.Doc, &n.Comment
+	case *ast.Field:
+		return &n.Doc, &n.Comment
+	}
+	return nil, nil
+}
+
+// positioned returns a copy of cg whose comments are at pos.
+func positioned(cg *ast.CommentGroup, pos token.Pos) *ast.CommentGroup {
+	if cg == nil {
+		return nil
+	}
+
+	result := &ast.CommentGroup{}
+	for _, c := range cg.List {
+		result.List = append(result.List, &ast.Comment{Slash: pos, Text: c.Text})
+	}
+	return result
+}
+
+// mergeConsts merges the const blocks which don't use iota in one block, put
+// first. The doc of a merged block goes to its first const.
+func mergeConsts(consts []*ast.GenDecl) []ast.Decl {
+	merged := &ast.GenDecl{Tok: token.CONST, Lparen: 1}
+	var result []ast.Decl
+
+	for _, decl := range consts {
+		if usesIota(decl) {
+			result = append(result, decl)
+			continue
+		}
+
+		for i, spec := range decl.Specs {
+			if vs, ok := spec.(*ast.ValueSpec); ok && i == 0 && vs.Doc == nil {
+				vs.Doc = decl.Doc
+			}
+			merged.Specs = append(merged.Specs, spec)
+		}
+	}
+
+	if len(merged.Specs) > 0 {
+		result = append([]ast.Decl{merged}, result...)
+	}
+	return result
+}
+
+func usesIota(decl *ast.GenDecl) bool {
+	found := false
+	ast.Inspect(decl, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Name == "iota" {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// sourceError returns err, a syntax error of the generated src, along with the
+// lines around it instead of the whole source.
+func sourceError(err error, src []byte) error {
+	errs, ok := err.(scanner.ErrorList)
+	if !ok || len(errs) == 0 {
+		return err
+	}
+
+	lines := strings.Split(string(src), "\n")
+	line := errs[0].Pos.Line
+
+	var sb strings.Builder
+	for i := line - 3; i < line+2; i++ {
+		if i < 0 || i >= len(lines) {
+			continue
+		}
+		marker := "  "
+		if i == line-1 {
+			marker = "> "
+		}
+		sb.WriteString(fmt.Sprintf("%s%4d | %s\n", marker, i+1, lines[i]))
+	}
+
+	return fmt.Errorf("%w\n\n%s", err, sb.String())
+}
@@ -0,0 +1,113 @@
+package generator
+
+import (
+	"bytes"
+	"github.com/expgo/ag/api"
+	"github.com/stretchr/testify/assert"
+	"go/ast"
+	"go/format"
+	"testing"
+)
+
+func TestPrintDecls(t *testing.T) {
+	code := &api.Code{}
+	code.Const(api.ConstSpec{Name: "ColorRed", Type: "Color", Value: "iota", Doc: "ColorRed is red."}, api.ConstSpec{Name: "ColorGreen"})
+	code.Struct("Palette", "Palette holds colors.\nIt is sorted.",
+		api.Field{Name: "Colors", Type: "[]Color", Tag: `json:"colors"`, Doc: "Colors are the colors."},
+		api.Field{Type: "sync.Mutex"},
+	)
+	code.Func("ParseColor", []api.Field{{Name: "name", Type: "string"}}, []api.Field{{Type: "Color"}, {Type: "error"}}, `
+		if name == "red" {
+			return ColorRed, nil
+		}
+		return 0, fmt.Errorf("invalid color %s", name)`)
+	code.Method(api.Field{Name: "x", Type: "*Color"}, "IsRed", nil, []api.Field{{Type: "bool"}}, "return *x == ColorRed")
+
+	decls, err := code.Decls()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	buf := bytes.NewBufferString("package x\n\n")
+	if !assert.NoError(t, printDecls(buf, decls)) {
+		return
+	}
+	src, err := format.Source(buf.Bytes())
+	if !assert.NoError(t, err, buf.String()) {
+		return
+	}
+
+	assert.Equal(t, `package x
+
+const (
+	// ColorRed is red.
+	ColorRed Color = iota
+	ColorGreen
+)
+
+// Palette holds colors.
+// It is sorted.
+type Palette struct {
+	// Colors are the colors.
+	Colors []Color `+"`"+`json:"colors"`+"`"+`
+	sync.Mutex
+}
+
+func ParseColor(name string) (Color, error) {
+	if name == "red" {
+		return ColorRed, nil
+	}
+	return 0, fmt.Errorf("invalid color %s", name)
+}
+
+func (x *Color) IsRed() bool {
+	return *x == ColorRed
+}
+`, string(src))
+
+	// the comments are back in place
+	assert.Equal(t, "ColorRed is red.\n", decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Doc.Text())
+}
+
+func TestMergeConsts(t *testing.T) {
+	enum := &api.Code{}
+	enum.Const(api.ConstSpec{Name: "ColorRed", Type: "Color", Value: "iota"}, api.ConstSpec{Name: "ColorGreen"})
+	enum.Const(api.ConstSpec{Name: "colorNames", Value: `"redgreen"`, Doc: "colorNames are the names."})
+	other := &api.Code{}
+	other.Const(api.ConstSpec{Name: "Version", Value: "1"}, api.ConstSpec{Name: "Name", Value: `"x"`})
+
+	var consts []*ast.GenDecl
+	for _, code := range []*api.Code{enum, other} {
+		decls, err := code.Decls()
+		if !assert.NoError(t, err) {
+			return
+		}
+		for _, decl := range decls {
+			consts = append(consts, decl.(*ast.GenDecl))
+		}
+	}
+
+	buf := bytes.NewBufferString("package x\n\n")
+	if !assert.NoError(t, printDecls(buf, mergeConsts(consts))) {
+		return
+	}
+	src, err := format.Source(buf.Bytes())
+	if !assert.NoError(t, err, buf.String()) {
+		return
+	}
+
+	assert.Equal(t, `package x
+
+const (
+	// colorNames are the names.
+	colorNames = "redgreen"
+	Version    = 1
+	Name       = "x"
+)
+
+const (
+	ColorRed Color = iota
+	ColorGreen
+)
+`, string(src))
+}
@@ -0,0 +1,110 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/expgo/ag/api"
+	"strings"
+)
+
+// orderFactories sorts factories with sortFactories, then moves the ones
+// implementing api.IDepend after the plugins they depend on, keeping the
+// sorted order otherwise. A dependency cycle is an error.
+func orderFactories(factories []api.GeneratorFactory) ([]api.GeneratorFactory, error) {
+	sortFactories(factories)
+
+	// deps[i] are the indexes of the factories running before factories[i]
+	deps := make([][]int, len(factories))
+	for i, f := range factories {
+		d, ok := f.(api.IDepend)
+		if !ok {
+			continue
+		}
+		for _, path := range d.DependsOn() {
+			for j, other := range factories {
+				if j != i && factoryPath(other) == path {
+					deps[i] = append(deps[i], j)
+				}
+			}
+		}
+	}
+
+	result := make([]api.GeneratorFactory, 0, len(factories))
+	done := make([]bool, len(factories))
+	for len(result) < len(factories) {
+		next := -1
+		for i := range factories {
+			if !done[i] && ready(deps[i], done) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, dependencyCycle(factories, deps, done)
+		}
+		done[next] = true
+		result = append(result, factories[next])
+	}
+
+	return result, nil
+}
+
+func ready(deps []int, done []bool) bool {
+	for _, d := range deps {
+		if !done[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// dependencyCycle returns the error of a cycle among the factories not done,
+// which all wait for another one.
+func dependencyCycle(factories []api.GeneratorFactory, deps [][]int, done []bool) error {
+	start := 0
+	for done[start] {
+		start++
+	}
+
+	// walk the waited for factories until one is met again
+	seen := map[int]int{}
+	var path []int
+	for i := start; ; {
+		if at, ok := seen[i]; ok {
+			path = append(path[at:], i)
+			break
+		}
+		seen[i] = len(path)
+		path = append(path, i)
+		for _, d := range deps[i] {
+			if !done[d] {
+				i = d
+				break
+			}
+		}
+	}
+
+	names := make([]string, len(path))
+	for k, i := range path {
+		names[k] = factoryPath(factories[i])
+	}
+	return fmt.Errorf("generator dependency cycle: %s", strings.Join(names, " -> "))
+}
+
+// shareSymbols gives each api.SymbolConsumer of gens the symbols declared by
+// the api.SymbolProvider generators running before it. plugins are the
+// package paths of gens.
+func shareSymbols(gens []api.Generator, plugins []string) {
+	var providers []int
+	for i, gen := range gens {
+		if sc, ok := gen.(api.SymbolConsumer); ok {
+			st := api.NewSymbolTable()
+			for _, p := range providers {
+				st.Declare(plugins[p], gens[p].(api.SymbolProvider).Symbols()...)
+			}
+			sc.UseSymbols(st)
+		}
+		if _, ok := gen.(api.SymbolProvider); ok {
+			providers = append(providers, i)
+		}
+	}
+}
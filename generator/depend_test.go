@@ -0,0 +1,72 @@
+package generator
+
+import (
+	"github.com/expgo/ag/api"
+	"github.com/expgo/factory"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testDependFactory struct {
+	testGenFactory
+	dependsOn []string
+}
+
+func (f *testDependFactory) DependsOn() []string { return f.dependsOn }
+
+func TestOrderFactories(t *testing.T) {
+	marshal := &testDependFactory{testGenFactory{name: "Marshal"}, []string{"github.com/expgo/enum", "example.com/unused"}}
+	factories := append([]api.GeneratorFactory{marshal}, factory.FindInterfaces[api.GeneratorFactory]()...)
+
+	ordered, err := orderFactories(factories)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// first by order, but after enum
+	var paths []string
+	for _, f := range ordered {
+		paths = append(paths, factoryPath(f))
+		if f == marshal {
+			break
+		}
+	}
+	assert.Equal(t, "github.com/expgo/enum", paths[len(paths)-2])
+	assert.Less(t, len(paths), len(ordered))
+
+	self := []string{"github.com/expgo/ag/generator"}
+	_, err = orderFactories([]api.GeneratorFactory{
+		&testDependFactory{testGenFactory{name: "A"}, self},
+		&testDependFactory{testGenFactory{name: "B"}, self},
+	})
+	assert.EqualError(t, err, "generator dependency cycle: "+
+		"github.com/expgo/ag/generator -> github.com/expgo/ag/generator -> github.com/expgo/ag/generator")
+}
+
+type testSymbolGenerator struct {
+	testGenerator
+	symbols []string
+	used    *api.SymbolTable
+}
+
+func (g *testSymbolGenerator) Symbols() []string { return g.symbols }
+
+func (g *testSymbolGenerator) UseSymbols(st *api.SymbolTable) { g.used = st }
+
+func TestShareSymbols(t *testing.T) {
+	enum := &testSymbolGenerator{symbols: []string{"ParseColor", "ColorNames"}}
+	marshal := &testSymbolGenerator{symbols: []string{"MarshalColor"}}
+	last := &testSymbolGenerator{}
+
+	shareSymbols([]api.Generator{enum, &testGenerator{}, marshal, last}, []string{"enum", "equal", "marshal", "last"})
+
+	assert.False(t, enum.used.Has("ParseColor"))
+
+	plugin, ok := marshal.used.Lookup("ParseColor")
+	assert.True(t, ok)
+	assert.Equal(t, "enum", plugin)
+	assert.False(t, marshal.used.Has("MarshalColor"))
+
+	assert.Equal(t, []string{"ColorNames", "ParseColor"}, last.used.Symbols("enum"))
+	assert.Equal(t, []string{"MarshalColor"}, last.used.Symbols("marshal"))
+}
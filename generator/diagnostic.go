@@ -0,0 +1,47 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/expgo/ag/api"
+	"sort"
+)
+
+// reportDiagnostics prints the diagnostics sorted by position, and returns an
+// error if some are errors, or warnings with warningsAsErrors.
+func reportDiagnostics(ds *api.Diagnostics, warningsAsErrors bool) error {
+	list := ds.List()
+	sort.SliceStable(list, func(i, j int) bool {
+		x := list[i].Pos
+		y := list[j].Pos
+		if x.Filename == y.Filename {
+			return x.Offset < y.Offset
+		}
+		return x.Filename < y.Filename
+	})
+
+	errs, warnings := 0, 0
+	for _, d := range list {
+		println(d.String())
+		if d.Severity == api.SeverityError {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+
+	switch {
+	case errs > 0:
+		return fmt.Errorf("generate: %s, %s", count(errs, "error"), count(warnings, "warning"))
+	case warningsAsErrors && warnings > 0:
+		return fmt.Errorf("generate: %s, treated as errors", count(warnings, "warning"))
+	}
+	return nil
+}
+
+// count returns n and noun, in the plural unless n is 1.
+func count(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -2,231 +2,495 @@ package generator
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/expgo/ag"
 	"github.com/expgo/ag/api"
 	"github.com/expgo/factory"
+	"go/ast"
+	"go/token"
 	"golang.org/x/tools/imports"
-	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
 )
 
-func filterTypedAnnotation(typedAnnotations []*api.TypedAnnotation, annotationMap map[string][]api.AnnotationType) []*api.TypedAnnotation {
-	filteredAnnotations := []*api.TypedAnnotation{}
-	for _, ta := range typedAnnotations {
-		if ta.Annotations != nil {
-			for name, annotationTypes := range annotationMap {
-				if an := ta.Annotations.FindAnnotationByName(name); an != nil {
-					for _, annotationType := range annotationTypes {
-						if annotationType == ta.Type {
-							filteredAnnotations = append(filteredAnnotations, ta)
-							break
-						}
-					}
-				}
-			}
-		}
-	}
-
-	distinctAnnotations := []*api.TypedAnnotation{}
-	for _, annotationType := range filteredAnnotations {
-		contains := false
-		for _, da := range distinctAnnotations {
-			if da == annotationType {
-				contains = true
-				break
-			}
-		}
-
-		if !contains {
-			distinctAnnotations = append(distinctAnnotations, annotationType)
-		}
-	}
-
-	return distinctAnnotations
-}
-
-func getAllTypedAnnotations(filename string, typeMaps map[api.AnnotationType][]string, packageMode bool) (result []*api.TypedAnnotation, packageName string, e error) {
+// getAllTypedAnnotations parses filename, and the other go files of its
+// directory in package mode. Malformed annotations don't stop the parsing:
+// their errors are returned as an ag.ParseErrors along with the valid
+// annotations.
+func getAllTypedAnnotations(fsys api.FS, filename string, typeMaps map[api.AnnotationType][]string, packageMode bool) (result []*api.TypedAnnotation, packageName string, e error) {
 	filename, e = filepath.Abs(filename)
 	if e != nil {
 		return
 	}
 
-	if packageMode {
-		result, packageName, e = ag.ParseFile(filename, typeMaps)
+	var parseErrs ag.ParseErrors
 
-		workDir, err := os.Getwd()
-		if err != nil {
-			e = err
-			return
-		}
+	result, packageName, e = ag.ParseFileFS(fsys, filename, typeMaps)
+	if errs, ok := e.(ag.ParseErrors); ok {
+		parseErrs = append(parseErrs, errs...)
+	} else if e != nil {
+		return nil, "", e
+	}
+
+	if packageMode {
 		// 获取当前目录下除filename和_test.go后缀的所有go文件
-		files, err := filepath.Glob(filepath.Join(workDir, "*.go"))
+		files, err := fsys.Glob(filepath.Join(filepath.Dir(filename), "*.go"))
 		if err != nil {
 			return nil, "", err
 		}
 		for _, file := range files {
 			if file != filename && !strings.HasSuffix(file, "_test.go") {
-				ta, _, err := ag.ParseFile(file, typeMaps)
-				if err != nil {
+				ta, _, err := ag.ParseFileFS(fsys, file, typeMaps)
+				if errs, ok := err.(ag.ParseErrors); ok {
+					parseErrs = append(parseErrs, errs...)
+				} else if err != nil {
 					return nil, "", err
 				}
 				result = append(result, ta...)
 			}
 		}
+	}
 
-		return
-	} else {
-		return ag.ParseFile(filename, typeMaps)
+	sortTypedAnnotations(result)
+
+	if len(parseErrs) > 0 {
+		return result, packageName, parseErrs
 	}
+
+	return result, packageName, nil
 }
 
-func GenerateFile(filename string, outputSuffix string, packageMode bool) {
-	factories := factory.FindInterfaces[api.GeneratorFactory]()
-	if len(factories) == 0 {
-		println("No GeneratorFactory was found for the annotation generator.")
-		return
+// fileInfos returns the FileInfo of the annotations, each one once.
+func fileInfos(typedAnnotations []*api.TypedAnnotation) []*api.FileInfo {
+	var result []*api.FileInfo
+	seen := map[*api.FileInfo]bool{}
+	for _, ta := range typedAnnotations {
+		if ta.FileInfo != nil && !seen[ta.FileInfo] {
+			seen[ta.FileInfo] = true
+			result = append(result, ta.FileInfo)
+		}
 	}
+	return result
+}
 
-	// sort factories by order
-	sort.Slice(factories, func(i, j int) bool {
+// sortFactories sorts factories by api.IOrder, then by package path and type
+// name, whatever order they were registered in.
+func sortFactories(factories []api.GeneratorFactory) {
+	order := func(f api.GeneratorFactory) api.Order {
+		if o, ok := f.(api.IOrder); ok {
+			return o.Order()
+		}
+		return api.OrderNormal
+	}
+	typePath := func(f api.GeneratorFactory) string {
+		t := reflect.TypeOf(f)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		return t.PkgPath() + "." + t.Name()
+	}
+
+	sort.SliceStable(factories, func(i, j int) bool {
 		x := factories[i]
 		y := factories[j]
 
-		xOrder := api.OrderNormal
-		if xO, ok := y.(api.IOrder); ok {
-			xOrder = xO.Order()
+		xOrder := order(x)
+		yOrder := order(y)
+		if xOrder == yOrder {
+			return typePath(x) < typePath(y)
 		}
-		yOrder := api.OrderNormal
-		if yO, ok := y.(api.IOrder); ok {
-			yOrder = yO.Order()
+		return xOrder.Val() < yOrder.Val()
+	})
+}
+
+// sortTypedAnnotations sorts typedAnnotations by file path, then by position
+// of their declaration in the file, the one of the method for a receiver.
+func sortTypedAnnotations(typedAnnotations []*api.TypedAnnotation) {
+	path := func(ta *api.TypedAnnotation) string {
+		if ta.FileInfo == nil {
+			return ""
 		}
+		return ta.FileInfo.FileFullAbsLocalPath
+	}
+	pos := func(ta *api.TypedAnnotation) token.Pos {
+		if ta.Type == api.AnnotationTypeFuncRecv && ta.Parent != nil {
+			return ta.Parent.Node.Pos()
+		}
+		return ta.Node.Pos()
+	}
 
-		if xOrder == yOrder {
-			xTypePath := reflect.TypeOf(x).Elem().PkgPath()
-			yTypePath := reflect.TypeOf(y).Elem().PkgPath()
-			return strings.Compare(xTypePath, yTypePath) < 0
+	sort.SliceStable(typedAnnotations, func(i, j int) bool {
+		x := typedAnnotations[i]
+		y := typedAnnotations[j]
+
+		if path(x) == path(y) {
+			return pos(x) < pos(y)
 		}
-		return xOrder.Val()-yOrder.Val() < 0
+		return path(x) < path(y)
 	})
+}
 
-	typeMaps := map[api.AnnotationType][]string{}
-	for _, f := range factories {
-		annotations := f.Annotations()
-		for name, types := range annotations {
-			for _, t := range types {
-				typeMaps[t] = append(typeMaps[t], name)
-			}
-		}
+// Options controls how GenerateWithOptions generates a file.
+type Options struct {
+	OutputSuffix string
+	PackageMode  bool
+	KeepGoing    bool // if true, generate for the valid annotations even if some are malformed
+	// BuildConstraint is a build constraint expression added to the one of
+	// the source file and of the config, like linux && !race.
+	BuildConstraint string
+	// WarningsAsErrors fails the generation on the warnings of the generators
+	// too.
+	WarningsAsErrors bool
+	// Factories, if set, are used instead of the registered GeneratorFactory,
+	// like in tests.
+	Factories []api.GeneratorFactory
+	// Diagnostics, if set, collects the diagnostics of the generators, which
+	// are printed anyway.
+	Diagnostics *api.Diagnostics
+	// FS, if set, is the file system the sources, go.mod and ag.yaml are read
+	// from instead of the disk, like an api.Overlay of unsaved buffers. The
+	// generated files are written to it, it must be an api.WriteFS.
+	FS api.FS
+}
+
+func GenerateFile(filename string, outputSuffix string, packageMode bool) {
+	GenerateWithOptions(filename, &Options{OutputSuffix: outputSuffix, PackageMode: packageMode})
+}
+
+/*
+GenerateWithOptions generates the file of the annotations of filename. The
+generated files only depend on the sources and the plugins: the generators
+run, and write, in the order of their factories, sorted by api.IOrder then by
+package path, after the plugins they depend on with api.IDepend, and each one
+gets its annotations sorted by file path then by position in the file.
+
+The diagnostics reported by the generators are printed as file:line:col
+messages. Nothing is written if one of them is an error, or a warning with
+Options.WarningsAsErrors, and an error is returned then.
+*/
+func GenerateWithOptions(filename string, options *Options) error {
+	factories := append([]api.GeneratorFactory{}, options.Factories...)
+	if len(factories) == 0 {
+		factories = factory.FindInterfaces[api.GeneratorFactory]()
+	}
+	if len(factories) == 0 {
+		println("No GeneratorFactory was found for the annotation generator.")
+		return nil
 	}
 
-	typedAnnotations, packageName, err := getAllTypedAnnotations(filename, typeMaps, packageMode)
+	err := generate(filename, options, factories)
 	if err != nil {
 		println(err.Error())
-		return
+	}
+	return err
+}
+
+func generate(filename string, options *Options, factories []api.GeneratorFactory) (err error) {
+	outputSuffix := options.OutputSuffix
+	fsys := api.OrOS(options.FS)
+	writer, err := api.Writer(options.FS)
+	if err != nil {
+		return err
+	}
+
+	factories, err = orderFactories(factories)
+	if err != nil {
+		return err
+	}
+
+	fileInfo, err := api.GetFileInfoFS(fsys, filename)
+	if err != nil {
+		return err
+	}
+
+	config, err := api.LoadConfigFS(fsys, fileInfo.ModuleAbsLocalPath)
+	if err != nil {
+		return err
+	}
+
+	buildConstraint, err := buildConstraint(fsys, filename, config.Build, options.BuildConstraint)
+	if err != nil {
+		return err
+	}
+
+	reg, err := newRegistry(factories, config.Aliases)
+	if err != nil {
+		return err
+	}
+
+	typedAnnotations, packageName, err := getAllTypedAnnotations(fsys, filename, reg.typeMaps(), options.PackageMode)
+	if parseErrs, ok := err.(ag.ParseErrors); ok {
+		if !options.KeepGoing {
+			return parseErrs
+		}
+		println(parseErrs.Error())
+		println("keep going with the valid annotations.")
+	} else if err != nil {
+		return err
 	}
 
+	outFilePath := fmt.Sprintf("%s%s.go", strings.TrimSuffix(fileInfo.FileFullAbsLocalPath, filepath.Ext(filename)), outputSuffix)
+	if strings.HasSuffix(filename, "_test.go") {
+		outFilePath = strings.Replace(outFilePath, "_test"+outputSuffix+".go", outputSuffix+"_test.go", 1)
+	}
+
+	outDir := filepath.Dir(outFilePath)
+	previous := previousOutputs(fsys, outFilePath)
+
 	if len(typedAnnotations) == 0 {
 		println("No annotation found.")
-		return
+		removeStaleOutputs(writer, outDir, fileInfo.ModuleAbsLocalPath, previous, nil)
+		return nil
 	}
 
+	// go list only runs in module mode
+	var pkg *packageInfo
+	if listable(fsys) && !fileInfo.GOPATHMode {
+		if pkg, err = loadPackage(fsys, fileInfo.FileFullAbsLocalPath, packageName); err != nil {
+			return err
+		}
+	}
+	if pkg == nil {
+		if pkg, err = memoryPackage(fileInfo, packageName); err != nil {
+			return err
+		}
+	}
+	packageName = pkg.name
+	for _, fi := range append([]*api.FileInfo{fileInfo}, fileInfos(typedAnnotations)...) {
+		fi.ImportPath = pkg.importPath
+		fi.PackageName = pkg.name
+		fi.OutputFile = outFilePath
+		fi.ExternalTest = pkg.externalTest
+	}
+
+	generated := []string{outFilePath}
+	for _, p := range previous {
+		generated = append(generated, filepath.Join(outDir, p.path))
+	}
+	declared, err := packageDecls(fsys, outDir, packageName, generated)
+	if err != nil {
+		return err
+	}
+
+	diagnostics := options.Diagnostics
+	if diagnostics == nil {
+		diagnostics = &api.Diagnostics{}
+	}
+
+	ctx := &api.GenerateContext{
+		PackageName: packageName,
+		PackagePath: pkg.importPath,
+		OutputFile:  outFilePath,
+		FileInfo:    fileInfo,
+		Annotations: typedAnnotations,
+		Config:      config,
+		Diagnostics: diagnostics,
+		Symbols:     api.NewSymbolTable(),
+		Imports:     api.NewImports(declared...),
+	}
+
+	// the diagnostics are reported once, before the files are written, or
+	// along with the error stopping the generation
+	reported := false
+	report := func() error {
+		reported = true
+		return reportDiagnostics(ctx.Diagnostics, options.WarningsAsErrors)
+	}
+	defer func() {
+		if !reported {
+			err = errors.Join(err, report())
+		}
+	}()
+
 	gens := []api.Generator{}
 
-	for _, f := range factories {
-		if ftas := filterTypedAnnotation(typedAnnotations, f.Annotations()); len(ftas) > 0 {
-			gen, e := f.New(ftas)
+	for i, f := range factories {
+		if ftas := reg.filterTypedAnnotation(typedAnnotations, i); len(ftas) > 0 {
+			var gen api.Generator
+			var e error
+			if cf, ok := f.(api.ContextFactory); ok {
+				gen, e = cf.NewWithContext(ctx, ftas)
+			} else {
+				gen, e = f.New(ftas)
+			}
 			if e != nil {
-				panic(e)
+				return fmt.Errorf("%s: %w", factoryPath(f), e)
 			}
 			if gen != nil {
 				gens = append(gens, gen)
+				if sp, ok := gen.(api.SymbolProvider); ok {
+					ctx.Symbols.Declare(pkgPath(gen), sp.Symbols()...)
+					ctx.Imports.Reserve(sp.Symbols()...)
+				}
 			}
 		}
 	}
 
 	if len(gens) == 0 {
 		println("No generator found.")
-		return
+		removeStaleOutputs(writer, outDir, fileInfo.ModuleAbsLocalPath, previous, nil)
+		return report()
 	}
 
-	buf := bytes.NewBuffer([]byte{})
-
 	plugins := []string{}
 	for _, gen := range gens {
-		t := reflect.TypeOf(gen)
-		if t.Kind() == reflect.Ptr {
-			t = t.Elem()
-		}
-		plugins = append(plugins, t.PkgPath())
+		plugins = append(plugins, pkgPath(gen))
 	}
 
 	println("run with plugins: \n", strings.Join(plugins, "\n"))
 
-	buf.WriteString("// Code generated by https://github.com/expgo/ag DO NOT EDIT.\n")
-	buf.WriteString("// Plugins: \n")
-	for _, plugin := range plugins {
-		buf.WriteString(fmt.Sprintf("//   - %s \n", plugin))
+	shareSymbols(gens, plugins)
+
+	outputs, err := collectOutputs(gens, plugins, outDir, fileInfo.ModuleAbsLocalPath, packageName)
+	if err != nil {
+		return err
 	}
-	buf.WriteString("\n\n")
 
-	// write package
-	buf.WriteString("package " + packageName)
-	buf.WriteString("\n\n")
+	records := []outputRecord{}
+	for _, o := range outputs {
+		if o.path == outFilePath {
+			return fmt.Errorf("generate: output %s is the generated file of %s", o.path, filename)
+		}
+		if err = o.format(config.Header, buildConstraint); err != nil {
+			return err
+		}
+		record, err := o.record(outDir)
+		if err != nil {
+			return err
+		}
+		for _, p := range previous {
+			if p.path == record.path {
+				o.previousHash = p.hash
+			}
+		}
+		records = append(records, record)
+	}
 
-	buf.WriteString("import (\n")
 	for i, gen := range gens {
 		println("Creating " + plugins[i] + " imports")
-		importList := gen.GetImports()
-		for _, imp := range importList {
-			buf.WriteString("\t\"" + imp + "\"\n")
+		// the imports of GetImports are used with their names, they can't be
+		// renamed
+		for _, imp := range gen.GetImports() {
+			name := api.ImportName(imp)
+			if used, ok := ctx.Imports.Name(imp); ok && used == name {
+				continue
+			}
+			switch importPath, ok := ctx.Imports.Used(name); {
+			case ok && len(importPath) > 0:
+				return fmt.Errorf("%s: import %s of GetImports collides with the import %s, named %s too", plugins[i], imp, importPath, name)
+			case ok:
+				return fmt.Errorf("%s: import %s of GetImports collides with the declaration %s of package %s", plugins[i], imp, name, packageName)
+			}
+			ctx.Imports.AddAs(imp, name)
+		}
+	}
+
+	// the body is written first, for the imports it adds to be in the
+	// import block
+	body := &bytes.Buffer{}
+
+	// the declarations of the api.DeclGenerator generators, their consts
+	// apart to be merged
+	genDecls := make([][]ast.Decl, len(gens))
+	var consts []*ast.GenDecl
+	for i, gen := range gens {
+		dg, ok := gen.(api.DeclGenerator)
+		if !ok {
+			continue
+		}
+		decls, err := dg.Decls()
+		if err != nil {
+			return fmt.Errorf("%s: %w", plugins[i], err)
+		}
+		for _, decl := range decls {
+			if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.CONST {
+				consts = append(consts, gd)
+			} else {
+				genDecls[i] = append(genDecls[i], decl)
+			}
 		}
 	}
-	buf.WriteString(")\n\n")
 
 	for i, gen := range gens {
 		println("Creating " + plugins[i] + " const")
-		err = gen.WriteConst(buf)
+		err = gen.WriteConst(body)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("%s: %w", plugins[i], err)
 		}
 	}
-	buf.WriteString("\n\n")
+	if err = printDecls(body, mergeConsts(consts)); err != nil {
+		return err
+	}
+	body.WriteString("\n\n")
 
 	for i, gen := range gens {
 		println("Creating " + plugins[i] + " func")
-		err = gen.WriteInitFunc(buf)
+		err = gen.WriteInitFunc(body)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("%s: %w", plugins[i], err)
 		}
 	}
-	buf.WriteString("\n\n")
+	body.WriteString("\n\n")
 
 	for i, gen := range gens {
 		println("Creating " + plugins[i] + " body")
-		err = gen.WriteBody(buf)
+		err = gen.WriteBody(body)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("%s: %w", plugins[i], err)
+		}
+		if err = printDecls(body, genDecls[i]); err != nil {
+			return fmt.Errorf("%s: %w", plugins[i], err)
 		}
 	}
 
-	formatted, err := imports.Process(packageName, buf.Bytes(), nil)
+	buf := bytes.NewBuffer([]byte{})
+
+	h := &header{banner: config.Header, constraint: buildConstraint, plugins: plugins, outputs: records}
+	h.write(buf)
+
+	// write package
+	buf.WriteString("package " + packageName)
+	buf.WriteString("\n\n")
+
+	buf.WriteString("import (\n")
+	for _, imp := range ctx.Imports.List() {
+		buf.WriteString("\t" + imp.String() + "\n")
+	}
+	buf.WriteString(")\n\n")
+	buf.Write(body.Bytes())
+
+	formatted, err := imports.Process(outFilePath, buf.Bytes(), nil)
 	if err != nil {
-		panic(fmt.Errorf("generate: error formatting code %s\n\n%s", err, buf.String()))
+		return fmt.Errorf("generate: error formatting code: %w", sourceError(err, buf.Bytes()))
 	}
 
-	outFilePath := fmt.Sprintf("%s%s.go", strings.TrimSuffix(filename, filepath.Ext(filename)), outputSuffix)
-	if strings.HasSuffix(filename, "_test.go") {
-		outFilePath = strings.Replace(outFilePath, "_test"+outputSuffix+".go", outputSuffix+"_test.go", 1)
+	if err = report(); err != nil {
+		return err
+	}
+
+	// nothing is written if an output would replace a file ag did not generate
+	for _, o := range outputs {
+		if err = o.checkOverwrite(writer); err != nil {
+			return err
+		}
 	}
 
-	mode := int(0o644)
-	err = os.WriteFile(outFilePath, formatted, os.FileMode(mode))
+	err = writer.WriteFile(outFilePath, formatted, 0o644)
 	if err != nil {
-		panic(fmt.Errorf("failed writing to file %s: %s", outFilePath, err))
+		return fmt.Errorf("failed writing to file %s: %s", outFilePath, err)
 	}
 	println("Finish write : " + outFilePath)
+
+	for _, o := range outputs {
+		if err = o.writeFile(writer); err != nil {
+			return err
+		}
+	}
+
+	removeStaleOutputs(writer, outDir, fileInfo.ModuleAbsLocalPath, previous, records)
+
+	return nil
 }
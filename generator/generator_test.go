@@ -0,0 +1,483 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/expgo/ag/api"
+	"github.com/stretchr/testify/assert"
+	"go/ast"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"text/template"
+)
+
+// writeModule writes files to a temporary directory, along with the go.mod of
+// the module example.com/x, and returns the directory.
+func writeModule(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": "module example.com/x\n\ngo 1.20\n"})
+	writeFiles(t, dir, files)
+	return dir
+}
+
+// writeFiles writes files to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+}
+
+type testGenerator struct {
+	name string
+	tas  []*api.TypedAnnotation
+}
+
+func (g *testGenerator) GetImports() []string             { return nil }
+func (g *testGenerator) WriteConst(wr io.Writer) error    { return nil }
+func (g *testGenerator) WriteInitFunc(wr io.Writer) error { return nil }
+
+func (g *testGenerator) WriteBody(wr io.Writer) error {
+	for _, ta := range g.tas {
+		name := ta.Node.(*ast.TypeSpec).Name.Name
+		if _, err := fmt.Fprintf(wr, "// %s: %s %s\n", g.name, name, filepath.Base(ta.FileInfo.FileFullAbsLocalPath)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// testGenFactory claims the annotation name on types, and keeps the context
+// of its last generator. Its generators are the ones of newGen, or
+// testGenerators writing a line per annotated type. Its order is
+// api.OrderFirst unless set.
+type testGenFactory struct {
+	name   string
+	order  api.Order
+	newGen func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error)
+	ctx    *api.GenerateContext
+}
+
+func (f *testGenFactory) Annotations() map[string][]api.AnnotationType {
+	return map[string][]api.AnnotationType{f.name: {api.AnnotationTypeType}}
+}
+
+func (f *testGenFactory) Order() api.Order { return f.order }
+
+func (f *testGenFactory) New(tas []*api.TypedAnnotation) (api.Generator, error) {
+	return &testGenerator{name: f.name, tas: tas}, nil
+}
+
+func (f *testGenFactory) NewWithContext(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+	f.ctx = ctx
+	if f.newGen == nil {
+		return f.New(tas)
+	}
+	return f.newGen(ctx, tas)
+}
+
+// deprecated returns a testGenFactory.newGen warning that the first type
+// annotated with name is deprecated.
+func deprecated(name string) func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+	return func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+		ctx.Diagnostics.Warnf(tas[0].Position(), "%s is deprecated", name)
+		return &testGenerator{name: name, tas: tas}, nil
+	}
+}
+
+// withOutputs returns a testGenFactory.newGen writing outputs.
+func withOutputs(outputs ...*api.Output) func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+	return func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+		return &testOutputGenerator{BaseGenerator: api.BaseGenerator[struct{}]{Tmpl: template.New("output")}, outputs: outputs}, nil
+	}
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"b.go": "package x\n\n// @Normal @High\ntype Beta int\n",
+		"a.go": "package x\n\n//go:generate ag\n\n// @High\ntype Zed int\n\n// @Normal\ntype Alpha int\n",
+	})
+
+	filename := filepath.Join(dir, "a.go")
+	options := &Options{OutputSuffix: "_ag", PackageMode: true}
+
+	expected := `// Code generated by https://github.com/expgo/ag DO NOT EDIT.
+// Plugins:
+//   - github.com/expgo/ag/generator
+//   - github.com/expgo/ag/generator
+
+package x
+
+// High: Zed a.go
+// High: Beta b.go
+// Normal: Alpha a.go
+// Normal: Beta b.go
+`
+
+	normal := &testGenFactory{name: "Normal", order: api.OrderNormal}
+	high := &testGenFactory{name: "High", order: api.OrderHigh}
+	for _, factories := range [][]api.GeneratorFactory{{normal, high}, {high, normal}} {
+		assert.NoError(t, generate(filename, options, factories))
+
+		content, err := os.ReadFile(filepath.Join(dir, "a_ag.go"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, string(content))
+			assert.False(t, strings.Contains(string(content), dir))
+		}
+	}
+}
+
+func TestGenerateContext(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a.go": "package x\n\n//go:generate ag\n\n// @Enum\ntype Zed int\n\n// @Context\ntype Alpha int\n",
+	})
+
+	f := &testGenFactory{name: "Context", order: api.OrderNormal, newGen: deprecated("Context")}
+	enum := &testGenFactory{name: "Enum", newGen: func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+		return &testSymbolGenerator{testGenerator: testGenerator{name: "Enum", tas: tas}, symbols: []string{"ParseZed"}}, nil
+	}}
+	err := generate(filepath.Join(dir, "a.go"), &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{f, enum})
+	assert.NoError(t, err)
+
+	ctx := f.ctx
+	if !assert.NotNil(t, ctx) {
+		return
+	}
+	assert.Equal(t, "x", ctx.PackageName)
+	assert.Equal(t, "example.com/x", ctx.PackagePath)
+	assert.Equal(t, filepath.Join(dir, "a_ag.go"), ctx.OutputFile)
+	assert.Equal(t, dir, ctx.FileInfo.ModuleAbsLocalPath)
+	assert.Len(t, ctx.Annotations, 2)
+	assert.NotNil(t, ctx.Config)
+	assert.True(t, ctx.Symbols.Has("ParseZed"))
+
+	diagnostics := ctx.Diagnostics.List()
+	if assert.Len(t, diagnostics, 1) {
+		assert.Equal(t, filepath.Join(dir, "a.go")+":8:5: warning: Context is deprecated", diagnostics[0].String())
+	}
+}
+
+func TestGenerateDiagnostics(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a.go": "package x\n\n//go:generate ag\n\n// @Error\ntype Zed int\n\n// @Context\ntype Alpha int\n",
+	})
+	filename := filepath.Join(dir, "a.go")
+	output := filepath.Join(dir, "a_ag.go")
+
+	errs := &testGenFactory{name: "Error", newGen: func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+		for _, ta := range tas {
+			ctx.Diagnostics.Errorf(ta.Position(), "duplicate value")
+		}
+		return &testGenerator{name: "Error", tas: tas}, nil
+	}}
+
+	err := generate(filename, &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{
+		&testGenFactory{name: "Context", newGen: deprecated("Context")},
+		errs,
+	})
+	assert.EqualError(t, err, "generate: 1 error, 1 warning")
+	assert.NoFileExists(t, output)
+
+	err = generate(filename, &Options{OutputSuffix: "_ag", WarningsAsErrors: true}, []api.GeneratorFactory{
+		&testGenFactory{name: "Context", newGen: deprecated("Context")},
+	})
+	assert.EqualError(t, err, "generate: 1 warning, treated as errors")
+	assert.NoFileExists(t, output)
+
+	// the diagnostics come along with the error stopping the generation
+	err = generate(filename, &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{
+		errs,
+		&testGenFactory{name: "Context", newGen: withDecls("Context", "func Broken( {\n}\n")},
+	})
+	assert.ErrorContains(t, err, "generate: error formatting code")
+	assert.ErrorContains(t, err, "generate: 1 error, 0 warnings")
+	assert.NoFileExists(t, output)
+
+	err = generate(filename, &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{
+		&testGenFactory{name: "Context", newGen: deprecated("Context")},
+	})
+	assert.NoError(t, err)
+	assert.FileExists(t, output)
+}
+
+func TestGenerateOutputErrors(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a.go": "package x\n\n//go:generate ag\n\n// @Output\ntype Zed int\n",
+	})
+
+	for _, o := range []*api.Output{{Path: "a_ag.go"}, {Path: "bad.json", ContentType: api.ContentTypeJson, Content: []byte("{")}} {
+		err := generate(filepath.Join(dir, "a.go"), &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{
+			&testGenFactory{name: "Output", newGen: withOutputs(o)},
+		})
+		assert.Error(t, err, o.Path)
+		assert.NoFileExists(t, filepath.Join(dir, "a_ag.go"), o.Path)
+	}
+}
+
+func TestGenerateRemovesStaleOutputs(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a.go": "package x\n\n//go:generate ag\n\n// @Output\ntype Zed int\n",
+	})
+	filename := filepath.Join(dir, "a.go")
+	mock := filepath.Join(dir, "mocks", "zed_mock.go")
+
+	output := &testGenFactory{name: "Output", newGen: withOutputs(&api.Output{Path: "mocks/zed_mock.go", Content: []byte("type Zed struct{}")})}
+	assert.NoError(t, generate(filename, &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{output}))
+	assert.FileExists(t, mock)
+
+	// no generator is created for the annotations
+	none := &testGenFactory{name: "Output", newGen: func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+		return nil, nil
+	}}
+	assert.NoError(t, generate(filename, &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{none}))
+	assert.NoFileExists(t, mock)
+
+	assert.NoError(t, generate(filename, &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{output}))
+	assert.FileExists(t, mock)
+
+	// the annotation is removed
+	assert.NoError(t, os.WriteFile(filename, []byte("package x\n\n//go:generate ag\n\ntype Zed int\n"), 0o644))
+	assert.NoError(t, generate(filename, &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{output}))
+	assert.NoFileExists(t, mock)
+}
+
+type testImportGenerator struct {
+	testGenerator
+	imports *api.Imports
+	errors  string
+}
+
+func (g *testImportGenerator) GetImports() []string { return []string{"fmt", "errors"} }
+
+func (g *testImportGenerator) WriteBody(wr io.Writer) error {
+	// added while writing, with a name goimports can't guess
+	strs := g.imports.AddAs("strings", "strs")
+	_, err := fmt.Fprintf(wr, "var ErrZed = %s.New(fmt.Sprint(%s.ToUpper(\"zed\")))\n", g.errors, strs)
+	return err
+}
+
+func TestGenerateImports(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a.go": "package x\n\n//go:generate ag\n\n// @Import\ntype Zed int\n",
+	})
+
+	f := &testGenFactory{name: "Import", newGen: func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+		return &testImportGenerator{imports: ctx.Imports, errors: ctx.Imports.Add("errors")}, nil
+	}}
+	err := generate(filepath.Join(dir, "a.go"), &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{f})
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dir, "a_ag.go"))
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(content), "import (\n\t\"errors\"\n\t\"fmt\"\n\tstrs \"strings\"\n)\n")
+		assert.Contains(t, string(content), "var ErrZed = errors.New(fmt.Sprint(strs.ToUpper(\"zed\")))\n")
+	}
+
+	// errors is declared by the package, GetImports can't rename its import
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b_test.go"), []byte("package x\n\nvar errors = 1\n"), 0o644))
+	err = generate(filepath.Join(dir, "a.go"), &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{f})
+	assert.EqualError(t, err, "github.com/expgo/ag/generator: import errors of GetImports collides with the declaration errors of package x")
+}
+
+func TestGenerateTemplateImports(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a.go": "package x\n\n//go:generate ag\n\nvar errors = 1\n\n// @Template\ntype Zed int\n",
+	})
+
+	f := &testGenFactory{name: "Template", newGen: func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+		tmpl, err := api.NewTemplate("template", ctx.Imports).Parse(`{{ define "body" }}
+var Err{{ .Name }} = {{ importName "errors" }}.New({{ quote .Name }})
+{{ end }}`)
+		if err != nil {
+			return nil, err
+		}
+
+		g := &api.BaseGenerator[ast.Ident]{Tmpl: tmpl}
+		for _, ta := range tas {
+			g.DataList = append(g.DataList, ta.Node.(*ast.TypeSpec).Name)
+		}
+		return g, nil
+	}}
+	err := generate(filepath.Join(dir, "a.go"), &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{f})
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dir, "a_ag.go"))
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(content), "import (\n\terrors1 \"errors\"\n)\n")
+		assert.Contains(t, string(content), "var ErrZed = errors1.New(\"Zed\")\n")
+	}
+
+	build := exec.Command("go", "build", "./...")
+	build.Dir = dir
+	out, err := build.CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
+
+type testDeclGenerator struct {
+	testGenerator
+	code *api.Code
+	body string
+}
+
+func (g *testDeclGenerator) Decls() ([]ast.Decl, error) { return g.code.Decls() }
+
+func (g *testDeclGenerator) WriteBody(wr io.Writer) error {
+	_, err := io.WriteString(wr, g.body)
+	return err
+}
+
+// withDecls returns a testGenFactory.newGen declaring a const and a func
+// suffixed with name for the first annotated type, and writing body.
+func withDecls(name string, body string) func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+	return func(ctx *api.GenerateContext, tas []*api.TypedAnnotation) (api.Generator, error) {
+		code := &api.Code{}
+		typeName := tas[0].Node.(*ast.TypeSpec).Name.Name
+		code.Const(api.ConstSpec{Name: typeName + name, Value: "1"})
+		code.Func("Is"+typeName+name, nil, []api.Field{{Type: "bool"}}, "return true")
+		return &testDeclGenerator{code: code, body: body}, nil
+	}
+}
+
+func TestGenerateDecls(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a.go": "package x\n\n//go:generate ag\n\n// @A @B\ntype Zed int\n",
+	})
+	filename := filepath.Join(dir, "a.go")
+
+	err := generate(filename, &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{
+		&testGenFactory{name: "A", newGen: withDecls("A", "")},
+		&testGenFactory{name: "B", newGen: withDecls("B", "// body B\n")},
+	})
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dir, "a_ag.go"))
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(content), `package x
+
+const (
+	ZedA = 1
+	ZedB = 1
+)
+
+func IsZedA() bool {
+	return true
+}
+
+// body B
+func IsZedB() bool {
+	return true
+}
+`)
+	}
+
+	err = generate(filename, &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{
+		&testGenFactory{name: "A", newGen: withDecls("A", "func Broken( {\n}\n")},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "\n> ")
+		assert.Contains(t, err.Error(), "| func Broken( {\n")
+	}
+}
+
+func TestGenerateOverlay(t *testing.T) {
+	dir := writeModule(t, map[string]string{"a.go": "package x\n"})
+
+	// the unsaved a.go and b.go, over the disk
+	overlay := api.NewOverlay(api.OS, map[string][]byte{
+		filepath.Join(dir, "a.go"): []byte("package x\n\n//go:generate ag\n\n// @Normal\ntype Alpha int\n"),
+		filepath.Join(dir, "b.go"): []byte("package x\n\n// @Normal\ntype Beta int\n"),
+	})
+	err := generate(filepath.Join(dir, "a.go"), &Options{OutputSuffix: "_ag", PackageMode: true, FS: overlay}, []api.GeneratorFactory{&testGenFactory{name: "Normal"}})
+	assert.NoError(t, err)
+
+	assert.Contains(t, string(overlay.Files[filepath.Join(dir, "a_ag.go")]), "// Normal: Alpha a.go\n// Normal: Beta b.go\n")
+	_, err = os.Stat(filepath.Join(dir, "a_ag.go"))
+	assert.True(t, os.IsNotExist(err), "nothing is written to the disk")
+
+	// only in memory
+	memory := api.NewOverlay(nil, map[string][]byte{
+		"/virtual/x/go.mod": []byte("module example.com/x\n\ngo 1.20\n"),
+		"/virtual/x/a.go":   []byte("package x\n\n//go:generate ag\n\n// @Normal\ntype Alpha int\n"),
+	})
+	f := &testGenFactory{name: "Normal"}
+	err = generate("/virtual/x/a.go", &Options{OutputSuffix: "_ag", FS: memory}, []api.GeneratorFactory{f})
+	assert.NoError(t, err)
+	assert.Contains(t, string(memory.Files["/virtual/x/a_ag.go"]), "package x\n\n// Normal: Alpha a.go\n")
+	if assert.NotNil(t, f.ctx, "the package of a file only in memory is not listed") {
+		assert.Equal(t, "example.com/x", f.ctx.PackagePath)
+	}
+
+	// read only, nothing is written to the disk at its mount point
+	mounted := api.MountFS(dir, fstest.MapFS{
+		"go.mod": {Data: []byte("module example.com/x\n\ngo 1.20\n")},
+		"c.go":   {Data: []byte("package x\n\n//go:generate ag\n\n// @Normal\ntype Gamma int\n")},
+	})
+	err = generate(filepath.Join(dir, "c.go"), &Options{OutputSuffix: "_ag", FS: mounted}, []api.GeneratorFactory{&testGenFactory{name: "Normal"}})
+	assert.ErrorContains(t, err, "not a WriteFS")
+	assert.NoFileExists(t, filepath.Join(dir, "c_ag.go"))
+
+	overlay = api.NewOverlay(mounted, nil)
+	err = generate(filepath.Join(dir, "c.go"), &Options{OutputSuffix: "_ag", FS: overlay}, []api.GeneratorFactory{&testGenFactory{name: "Normal"}})
+	assert.NoError(t, err)
+	assert.Contains(t, string(overlay.Files[filepath.Join(dir, "c_ag.go")]), "// Normal: Gamma c.go\n")
+	assert.NoFileExists(t, filepath.Join(dir, "c_ag.go"))
+}
+
+func TestGeneratePackageInfo(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a.go":         "package x\n",
+		"b_test.go":    "package x_test\n\n//go:generate ag\n\n// @Context\ntype Zed int\n",
+		"c_windows.go": "package x\n\n//go:generate ag\n\n// @Context\ntype Yak int\n",
+	})
+
+	f := &testGenFactory{name: "Context"}
+	assert.NoError(t, generate(filepath.Join(dir, "b_test.go"), &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{f}))
+
+	if assert.NotNil(t, f.ctx) {
+		assert.Equal(t, "example.com/x_test", f.ctx.PackagePath)
+		assert.Equal(t, "x_test", f.ctx.PackageName)
+
+		fi := f.ctx.Annotations[0].FileInfo
+		assert.Equal(t, "example.com/x_test", fi.ImportPath)
+		assert.Equal(t, "x_test", fi.PackageName)
+		assert.Equal(t, filepath.Join(dir, "b_ag_test.go"), fi.OutputFile)
+		assert.True(t, fi.ExternalTest)
+	}
+	// excluded by build constraints
+	f.ctx = nil
+	assert.NoError(t, generate(filepath.Join(dir, "c_windows.go"), &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{f}))
+	if assert.NotNil(t, f.ctx) {
+		assert.Equal(t, "example.com/x", f.ctx.PackagePath)
+		assert.Equal(t, "x", f.ctx.PackageName)
+	}
+
+	// go list fails without go
+	t.Setenv("PATH", "")
+	err := generate(filepath.Join(dir, "b_test.go"), &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{f})
+	assert.ErrorContains(t, err, "go list")
+}
+
+func TestGenerateGOPATH(t *testing.T) {
+	gopath := t.TempDir()
+	t.Setenv("GOPATH", gopath)
+	dir := filepath.Join(gopath, "src", "example.com", "y")
+	writeFiles(t, dir, map[string]string{
+		"a.go":      "package y\n",
+		"b_test.go": "package y_test\n\n//go:generate ag\n\n// @Context\ntype Zed int\n",
+	})
+
+	f := &testGenFactory{name: "Context"}
+	assert.NoError(t, generate(filepath.Join(dir, "b_test.go"), &Options{OutputSuffix: "_ag"}, []api.GeneratorFactory{f}))
+
+	if assert.NotNil(t, f.ctx) {
+		assert.Equal(t, "example.com/y_test", f.ctx.PackagePath)
+		assert.Equal(t, "y_test", f.ctx.PackageName)
+		assert.True(t, f.ctx.Annotations[0].FileInfo.GOPATHMode)
+	}
+	_, err := os.Stat(filepath.Join(dir, "b_ag_test.go"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,164 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/expgo/ag/api"
+	"go/build/constraint"
+	"path/filepath"
+	"strings"
+)
+
+// generatedMarker is the line of the header telling a file was generated by
+// ag, and may be overwritten or removed by it.
+const generatedMarker = "// Code generated by https://github.com/expgo/ag DO NOT EDIT."
+
+// header is what is written before the package clause of a generated file.
+type header struct {
+	banner     string // free text, like a license, from the module config
+	constraint constraint.Expr
+	plugins    []string
+	outputs    []outputRecord
+}
+
+// write writes the banner, the generated code notice listing the plugins and
+// the other outputs, relative to the directory of the file and with the hash
+// of the non go ones, and the build constraint.
+func (h *header) write(buf *bytes.Buffer) {
+	if banner := strings.TrimRight(h.banner, "\n"); len(banner) > 0 {
+		for _, line := range strings.Split(banner, "\n") {
+			switch {
+			case strings.HasPrefix(line, "//"):
+				buf.WriteString(line + "\n")
+			case len(strings.TrimSpace(line)) == 0:
+				buf.WriteString("//\n")
+			default:
+				buf.WriteString("// " + line + "\n")
+			}
+		}
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString(generatedMarker + "\n")
+	buf.WriteString("// Plugins: \n")
+	for _, plugin := range h.plugins {
+		buf.WriteString(fmt.Sprintf("//   - %s \n", plugin))
+	}
+	if len(h.outputs) > 0 {
+		buf.WriteString("// Outputs: \n")
+		for _, o := range h.outputs {
+			buf.WriteString(fmt.Sprintf("//   - %s \n", o))
+		}
+	}
+	buf.WriteString("\n")
+
+	if h.constraint != nil {
+		buf.WriteString("//go:build " + h.constraint.String() + "\n\n")
+	}
+	buf.WriteString("\n")
+}
+
+var knownOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true, "hurd": true,
+	"illumos": true, "ios": true, "js": true, "linux": true, "nacl": true, "netbsd": true,
+	"openbsd": true, "plan9": true, "solaris": true, "wasip1": true, "windows": true, "zos": true,
+}
+
+var knownArch = map[string]bool{
+	"386": true, "amd64": true, "amd64p32": true, "arm": true, "armbe": true, "arm64": true,
+	"arm64be": true, "loong64": true, "mips": true, "mipsle": true, "mips64": true, "mips64le": true,
+	"mips64p32": true, "mips64p32le": true, "ppc": true, "ppc64": true, "ppc64le": true, "riscv": true,
+	"riscv64": true, "s390": true, "s390x": true, "sparc": true, "sparc64": true, "wasm": true,
+}
+
+// fileConstraint returns the build constraint of a go file: the one of its
+// //go:build or // +build lines, and the one of its _GOOS and _GOARCH name
+// suffixes, which the name of the generated file loses.
+func fileConstraint(fsys api.FS, filename string) (constraint.Expr, error) {
+	var exprs []constraint.Expr
+
+	name := strings.TrimSuffix(filepath.Base(filename), ".go")
+	if i := strings.Index(name, "_"); i >= 0 {
+		l := strings.Split(strings.TrimSuffix(name[i:], "_test"), "_")
+		n := len(l)
+		switch {
+		case n >= 2 && knownOS[l[n-2]] && knownArch[l[n-1]]:
+			exprs = append(exprs, &constraint.TagExpr{Tag: l[n-2]}, &constraint.TagExpr{Tag: l[n-1]})
+		case knownOS[l[n-1]] || knownArch[l[n-1]]:
+			exprs = append(exprs, &constraint.TagExpr{Tag: l[n-1]})
+		}
+	}
+
+	content, err := fsys.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var plusBuild []constraint.Expr
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+
+		switch {
+		case constraint.IsGoBuild(line):
+			expr, err := constraint.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", filename, err)
+			}
+			exprs = append(exprs, expr)
+			plusBuild = nil
+		case constraint.IsPlusBuild(line):
+			expr, err := constraint.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", filename, err)
+			}
+			plusBuild = append(plusBuild, expr)
+		}
+	}
+
+	// the // +build lines only count without a //go:build line
+	exprs = append(exprs, plusBuild...)
+
+	return andConstraints(exprs...), scanner.Err()
+}
+
+// andConstraints returns the conjunction of the non nil exprs, or nil.
+func andConstraints(exprs ...constraint.Expr) constraint.Expr {
+	var result constraint.Expr
+	for _, expr := range exprs {
+		switch {
+		case expr == nil:
+		case result == nil:
+			result = expr
+		default:
+			result = &constraint.AndExpr{X: result, Y: expr}
+		}
+	}
+	return result
+}
+
+// buildConstraint returns the build constraint of the files generated for
+// filename: the one of filename and of the custom exprs, like linux && !race.
+func buildConstraint(fsys api.FS, filename string, exprs ...string) (constraint.Expr, error) {
+	result, err := fileConstraint(fsys, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, text := range exprs {
+		if len(strings.TrimSpace(text)) == 0 {
+			continue
+		}
+		expr, err := constraint.Parse("//go:build " + text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build constraint %q: %w", text, err)
+		}
+		result = andConstraints(result, expr)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package generator
+
+import (
+	"bytes"
+	"github.com/expgo/ag/api"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildConstraint(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		content  string
+		custom   string
+		expected string
+	}{
+		{"color.go", "package x\n", "", ""},
+		{"color.go", "//go:build linux || darwin\n\npackage x\n", "", "linux || darwin"},
+		{"color.go", "// +build linux darwin\n// +build amd64\n\npackage x\n", "", "(linux || darwin) && amd64"},
+		{"color_linux.go", "package x\n", "", "linux"},
+		{"color_windows_amd64_test.go", "package x\n", "", "windows && amd64"},
+		{"color_arm64.go", "//go:build !race\n\npackage x\n", "cgo", "arm64 && !race && cgo"},
+		{"color.go", "package x\n\n//go:build linux\n", "", ""},
+	}
+
+	for _, tt := range tests {
+		filename := filepath.Join(dir, tt.name)
+		assert.NoError(t, os.WriteFile(filename, []byte(tt.content), 0o644))
+
+		expr, err := buildConstraint(api.OS, filename, "", tt.custom)
+		if !assert.NoError(t, err, tt.content) {
+			continue
+		}
+		if len(tt.expected) == 0 {
+			assert.Nil(t, expr, tt.content)
+		} else if assert.NotNil(t, expr, tt.content) {
+			assert.Equal(t, tt.expected, expr.String(), tt.content)
+		}
+	}
+
+	_, err := buildConstraint(api.OS, filepath.Join(dir, "color.go"), "linux &&")
+	assert.Error(t, err)
+}
+
+func TestHeaderWrite(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "color_linux.go")
+	assert.NoError(t, os.WriteFile(filename, []byte("package x\n"), 0o644))
+	expr, err := buildConstraint(api.OS, filename, "!race")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	h := &header{
+		banner:     "Copyright the ag authors.\n\n// SPDX-License-Identifier: MIT\n",
+		constraint: expr,
+		plugins:    []string{"github.com/expgo/enum"},
+	}
+	h.write(buf)
+
+	expected := `// Copyright the ag authors.
+//
+// SPDX-License-Identifier: MIT
+
+// Code generated by https://github.com/expgo/ag DO NOT EDIT.
+// Plugins: 
+//   - github.com/expgo/enum 
+
+//go:build linux && !race
+
+
+`
+	assert.Equal(t, expected, buf.String())
+}
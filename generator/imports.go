@@ -0,0 +1,66 @@
+package generator
+
+import (
+	"github.com/expgo/ag/api"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+)
+
+// packageDecls returns the names declared at the top level of the go files of
+// package packageName in dir, test files included, except the files of
+// exclude, which are generated.
+func packageDecls(fsys api.FS, dir string, packageName string, exclude []string) ([]string, error) {
+	files, err := fsys.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := map[string]bool{}
+	for _, e := range exclude {
+		excluded[e] = true
+	}
+
+	var result []string
+	fileSet := token.NewFileSet()
+	for _, file := range files {
+		if excluded[file] {
+			continue
+		}
+
+		content, err := fsys.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		fileNode, err := parser.ParseFile(fileSet, file, content, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		if fileNode.Name.Name != packageName {
+			continue
+		}
+
+		for _, decl := range fileNode.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					result = append(result, d.Name.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						result = append(result, s.Name.Name)
+					case *ast.ValueSpec:
+						for _, name := range s.Names {
+							result = append(result, name.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,279 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/expgo/ag/api"
+	"go/build/constraint"
+	"golang.org/x/tools/imports"
+	"path/filepath"
+	"strings"
+)
+
+// output is a file written for the outputs of one or several generators.
+type output struct {
+	path        string
+	contentType api.ContentType
+	packageName string
+	plugins     []string
+	content     bytes.Buffer
+	formatted   []byte
+	// previousHash is the hash the output was recorded with by the previous
+	// generation, when it is not go.
+	previousHash string
+}
+
+// collectOutputs returns the outputs of the generators, the go ones merged by
+// path. The paths are made absolute against dir, the directory of the source
+// file, or moduleDir, and must stay in moduleDir.
+func collectOutputs(gens []api.Generator, plugins []string, dir string, moduleDir string, packageName string) ([]*output, error) {
+	var result []*output
+	byPath := map[string]*output{}
+
+	for i, gen := range gens {
+		og, ok := gen.(api.OutputGenerator)
+		if !ok {
+			continue
+		}
+
+		println("Creating " + plugins[i] + " outputs")
+		outputs, err := og.Outputs()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", plugins[i], err)
+		}
+
+		for _, o := range outputs {
+			base := dir
+			if o.ModuleRoot {
+				base = moduleDir
+			}
+			path := filepath.Clean(filepath.Join(base, o.Path))
+			if !within(moduleDir, path) {
+				return nil, fmt.Errorf("%s: output %s is out of the module %s", plugins[i], o.Path, moduleDir)
+			}
+
+			contentType := o.ContentType
+			pkg := ""
+			if o.IsGo() {
+				contentType = api.ContentTypeGo
+				pkg = o.Package
+				if len(pkg) == 0 {
+					pkg = packageName
+					if filepath.Dir(path) != dir {
+						pkg = filepath.Base(filepath.Dir(path))
+					}
+				}
+			}
+
+			out, ok := byPath[path]
+			switch {
+			case !ok:
+				out = &output{path: path, contentType: contentType, packageName: pkg}
+				byPath[path] = out
+				result = append(result, out)
+			case !o.IsGo() || out.contentType != api.ContentTypeGo:
+				return nil, fmt.Errorf("output %s is written twice, only go outputs can be merged", o.Path)
+			case out.packageName != pkg:
+				return nil, fmt.Errorf("output %s is written in both package %s and %s", o.Path, out.packageName, pkg)
+			}
+
+			if len(out.plugins) == 0 || out.plugins[len(out.plugins)-1] != plugins[i] {
+				out.plugins = append(out.plugins, plugins[i])
+			}
+			out.content.Write(o.Content)
+			if o.IsGo() {
+				out.content.WriteString("\n")
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// format formats the content of the output. A go output gets a header with
+// banner and buildConstraint, like the generated file of the source, another
+// one goes through the formatter of its content type.
+func (o *output) format(banner string, buildConstraint constraint.Expr) (err error) {
+	if o.contentType != api.ContentTypeGo {
+		o.formatted = o.content.Bytes()
+		if formatter := api.GetOutputFormatter(o.contentType); formatter != nil {
+			if o.formatted, err = formatter(o.formatted); err != nil {
+				return fmt.Errorf("generate: invalid %s output %s: %w", o.contentType, o.path, err)
+			}
+		}
+		return nil
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	h := &header{banner: banner, constraint: buildConstraint, plugins: o.plugins}
+	h.write(buf)
+	buf.WriteString("package " + o.packageName)
+	buf.WriteString("\n\n")
+	buf.Write(o.content.Bytes())
+
+	o.formatted, err = imports.Process(o.path, buf.Bytes(), nil)
+	if err != nil {
+		return fmt.Errorf("generate: error formatting code: %w", sourceError(err, buf.Bytes()))
+	}
+	return nil
+}
+
+// record returns the entry of the formatted output in the header of the
+// generated file of dir.
+func (o *output) record(dir string) (outputRecord, error) {
+	rel, err := filepath.Rel(dir, o.path)
+	if err != nil {
+		return outputRecord{}, err
+	}
+
+	result := outputRecord{path: filepath.ToSlash(rel)}
+	if o.contentType != api.ContentTypeGo {
+		result.hash = contentHash(o.formatted)
+	}
+	return result, nil
+}
+
+// checkOverwrite returns an error if a file ag did not generate is at the path
+// of the formatted output: a go file without the generated marker, or another
+// file with neither the content recorded by the previous generation nor the
+// new one.
+func (o *output) checkOverwrite(fsys api.FS) error {
+	content, err := fsys.ReadFile(o.path)
+	switch {
+	case err != nil:
+		return nil
+	case o.contentType == api.ContentTypeGo && isGenerated(content):
+		return nil
+	case o.contentType != api.ContentTypeGo && (contentHash(content) == o.previousHash || bytes.Equal(content, o.formatted)):
+		return nil
+	}
+	return fmt.Errorf("generate: %s exists and was not generated by ag, or was changed since, it is not overwritten", o.path)
+}
+
+// writeFile writes the formatted output to its path.
+func (o *output) writeFile(fsys api.WriteFS) error {
+	if err := o.checkOverwrite(fsys); err != nil {
+		return err
+	}
+
+	if err := fsys.WriteFile(o.path, o.formatted, 0o644); err != nil {
+		return fmt.Errorf("failed writing to file %s: %s", o.path, err)
+	}
+
+	println("Finish write : " + o.path)
+	return nil
+}
+
+// outputRecord is an output listed in the header of the generated file. A non
+// go output has no generated marker, the hash of its content tells whether it
+// is still the one ag wrote.
+type outputRecord struct {
+	path string // relative to the directory of the generated file
+	hash string // of a non go output
+}
+
+func (r outputRecord) String() string {
+	if len(r.hash) == 0 {
+		return r.path
+	}
+	return r.path + " " + r.hash
+}
+
+// contentHash returns the hash an output is recorded with.
+func contentHash(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// previousOutputs returns the outputs listed in the header of the generated
+// file at path, or nil if there is no such file.
+func previousOutputs(fsys api.FS, path string) []outputRecord {
+	content, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var result []outputRecord
+	inOutputs := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "package "):
+			return result
+		case !strings.HasPrefix(line, "//"):
+			inOutputs = false
+		case line == "// Outputs:":
+			inOutputs = true
+		case inOutputs && strings.HasPrefix(line, "//   - "):
+			record := outputRecord{path: strings.TrimPrefix(line, "//   - ")}
+			if i := strings.LastIndex(record.path, " sha256:"); i >= 0 {
+				record.path, record.hash = record.path[:i], record.path[i+1:]
+			}
+			result = append(result, record)
+		default:
+			inOutputs = false
+		}
+	}
+
+	return result
+}
+
+// removeStaleOutputs removes the outputs listed in previous that are not
+// generated anymore. Both are relative to dir. Only the files of moduleDir
+// that are still the ones ag generated are removed.
+func removeStaleOutputs(fsys api.WriteFS, dir string, moduleDir string, previous []outputRecord, current []outputRecord) {
+	for _, p := range previous {
+		stale := true
+		for _, c := range current {
+			if c.path == p.path {
+				stale = false
+				break
+			}
+		}
+		if !stale {
+			continue
+		}
+
+		path := filepath.Join(dir, p.path)
+		if !within(moduleDir, path) {
+			continue
+		}
+		content, err := fsys.ReadFile(path)
+		switch {
+		case err != nil:
+			continue
+		case len(p.hash) == 0 && !isGenerated(content):
+			continue
+		case len(p.hash) > 0 && contentHash(content) != p.hash:
+			continue
+		}
+		if err := fsys.Remove(path); err == nil {
+			println("Remove stale output : " + path)
+		}
+	}
+}
+
+// isGenerated reports whether the header of content has the generated marker
+// of ag.
+func isGenerated(content []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == generatedMarker:
+			return true
+		case strings.HasPrefix(line, "package "):
+			return false
+		}
+	}
+	return false
+}
+
+// within reports whether path is dir or in it.
+func within(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
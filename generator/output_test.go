@@ -0,0 +1,149 @@
+package generator
+
+import (
+	"bytes"
+	"github.com/expgo/ag/api"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testOutputGenerator struct {
+	api.BaseGenerator[struct{}]
+	outputs []*api.Output
+}
+
+func (g *testOutputGenerator) Outputs() ([]*api.Output, error) { return g.outputs, nil }
+
+func TestCollectOutputs(t *testing.T) {
+	dir := t.TempDir()
+
+	gens := []api.Generator{
+		&testOutputGenerator{outputs: []*api.Output{
+			{Path: "color_ag_test.go", Content: []byte("func TestA() {}")},
+			{Path: "mocks/color_mock.go", Content: []byte("type A struct{}")},
+		}},
+		&api.BaseGenerator[struct{}]{},
+		&testOutputGenerator{outputs: []*api.Output{
+			{Path: "color_ag_test.go", Content: []byte("func TestB() {}")},
+		}},
+	}
+
+	outputs, err := collectOutputs(gens, []string{"p1", "p2", "p3"}, dir, dir, "x")
+	if !assert.NoError(t, err) || !assert.Len(t, outputs, 2) {
+		return
+	}
+
+	assert.Equal(t, filepath.Join(dir, "color_ag_test.go"), outputs[0].path)
+	assert.Equal(t, "x", outputs[0].packageName)
+	assert.Equal(t, []string{"p1", "p3"}, outputs[0].plugins)
+	assert.Equal(t, "func TestA() {}\nfunc TestB() {}\n", outputs[0].content.String())
+	assert.Equal(t, "mocks", outputs[1].packageName)
+
+	gens = append(gens, &testOutputGenerator{outputs: []*api.Output{
+		{Path: "mocks/color_mock.go", Package: "fakes"},
+	}})
+	_, err = collectOutputs(gens, []string{"p1", "p2", "p3", "p4"}, dir, dir, "x")
+	assert.Error(t, err)
+
+	gens = []api.Generator{&testOutputGenerator{outputs: []*api.Output{{Path: "../x.go"}}}}
+	_, err = collectOutputs(gens, []string{"p1"}, filepath.Join(dir, "pkg"), dir, "x")
+	assert.NoError(t, err)
+	_, err = collectOutputs(gens, []string{"p1"}, dir, dir, "x")
+	assert.ErrorContains(t, err, "out of the module")
+}
+
+func TestRemoveStaleOutputs(t *testing.T) {
+	dir := t.TempDir()
+	spec := []byte("{}\n")
+
+	buf := &bytes.Buffer{}
+	h := &header{banner: "Copyright the ag authors.", plugins: []string{"github.com/expgo/enum"}, outputs: []outputRecord{
+		{path: "a_test.go"}, {path: "mocks/b.go"}, {path: "c.go"}, {path: "../d.go"},
+		{path: "e.json", hash: contentHash(spec)}, {path: "f.json", hash: contentHash(spec)},
+	}}
+	h.write(buf)
+	main := filepath.Join(dir, "pkg", "x_ag.go")
+	for _, f := range []string{main, filepath.Join(dir, "pkg", "a_test.go"), filepath.Join(dir, "pkg", "mocks", "b.go"), filepath.Join(dir, "d.go")} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(f), 0o755))
+		assert.NoError(t, os.WriteFile(f, buf.Bytes(), 0o644))
+	}
+	// hand written since
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "pkg", "c.go"), []byte("package x\n"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "pkg", "e.json"), spec, 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "pkg", "f.json"), []byte("{\"edited\": true}\n"), 0o644))
+
+	previous := previousOutputs(api.OS, main)
+	assert.Equal(t, h.outputs, previous)
+
+	removeStaleOutputs(api.OS, filepath.Join(dir, "pkg"), filepath.Join(dir, "pkg"), previous, []outputRecord{{path: "a_test.go"}})
+	assert.FileExists(t, filepath.Join(dir, "pkg", "a_test.go"))
+	assert.NoFileExists(t, filepath.Join(dir, "pkg", "mocks", "b.go"))
+	assert.FileExists(t, filepath.Join(dir, "pkg", "c.go"), "a file ag did not generate is kept")
+	assert.FileExists(t, filepath.Join(dir, "d.go"), "a file out of the module is kept")
+	assert.NoFileExists(t, filepath.Join(dir, "pkg", "e.json"))
+	assert.FileExists(t, filepath.Join(dir, "pkg", "f.json"), "a file changed since it was generated is kept")
+}
+
+func TestWriteOutputOverHandWrittenFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "color.go")
+	assert.NoError(t, os.WriteFile(path, []byte("package x\n\ntype Color int\n"), 0o644))
+
+	o := &output{path: path, contentType: api.ContentTypeGo, packageName: "x"}
+	o.content.WriteString("func A() {}\n")
+	assert.NoError(t, o.format("", nil))
+	assert.ErrorContains(t, o.writeFile(api.OS), "not generated by ag")
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "package x\n\ntype Color int\n", string(content))
+
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, o.writeFile(api.OS))
+	assert.NoError(t, o.writeFile(api.OS), "a generated file is overwritten")
+}
+
+func TestWriteNonGoOutput(t *testing.T) {
+	dir := t.TempDir()
+
+	gens := []api.Generator{
+		&testOutputGenerator{outputs: []*api.Output{
+			{Path: "api/openapi.json", ModuleRoot: true, ContentType: api.ContentTypeJson, Content: []byte(`{"openapi":"3.0.0"}`)},
+			{Path: "schema.sql", ContentType: api.ContentTypeSql, Content: []byte("CREATE TABLE color (id INT);\n")},
+		}},
+	}
+
+	outputs, err := collectOutputs(gens, []string{"p1"}, filepath.Join(dir, "pkg"), dir, "x")
+	if !assert.NoError(t, err) || !assert.Len(t, outputs, 2) {
+		return
+	}
+
+	for _, o := range outputs {
+		assert.NoError(t, o.format("", nil))
+		assert.NoError(t, o.writeFile(api.OS))
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "api", "openapi.json"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "{\n  \"openapi\": \"3.0.0\"\n}\n", string(content))
+	}
+	assert.FileExists(t, filepath.Join(dir, "pkg", "schema.sql"))
+
+	record, err := outputs[0].record(filepath.Join(dir, "pkg"))
+	assert.NoError(t, err)
+	assert.Equal(t, outputRecord{path: "../api/openapi.json", hash: contentHash(content)}, record)
+
+	// a changed spec is overwritten only when it is the one recorded
+	outputs[0].content.Reset()
+	outputs[0].content.WriteString(`{"openapi":"3.1.0"}`)
+	assert.NoError(t, outputs[0].format("", nil))
+	assert.ErrorContains(t, outputs[0].writeFile(api.OS), "not generated by ag")
+	outputs[0].previousHash = record.hash
+	assert.NoError(t, outputs[0].writeFile(api.OS))
+
+	invalid := &output{path: filepath.Join(dir, "bad.json"), contentType: api.ContentTypeJson}
+	invalid.content.WriteString("{")
+	assert.Error(t, invalid.format("", nil))
+}
@@ -0,0 +1,167 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/expgo/ag/api"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// packageInfo is the package of a go file.
+type packageInfo struct {
+	importPath   string
+	name         string
+	externalTest bool
+}
+
+// listedPackage is what go list -json tells of a package.
+type listedPackage struct {
+	ImportPath     string
+	Name           string
+	ForTest        string
+	GoFiles        []string
+	IgnoredGoFiles []string
+	Error          *struct{ Err string }
+}
+
+// loadPackage loads the package of filename with go list -test, the data
+// go/packages is built from. The import path of a test package is the one
+// listed without its [pkg.test] suffix, and an external test package is a
+// test package of another import path than the package tested. A file
+// excluded by its build constraints is in the package listed with it, named
+// packageName, the one of its package clause, and in the external test
+// package when that is another _test name. The files of an api.Overlay are
+// given to go list as an overlay. It returns nil if go list does not see the
+// file.
+func loadPackage(fsys api.FS, filename string, packageName string) (*packageInfo, error) {
+	dir := filepath.Dir(filename)
+	args := []string{"list", "-e", "-test", "-json=ImportPath,Name,ForTest,GoFiles,IgnoredGoFiles,Error"}
+
+	if overlay, ok := fsys.(*api.Overlay); ok && len(overlay.Files) > 0 {
+		overlayFile, err := writeListOverlay(overlay)
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(filepath.Dir(overlayFile))
+		args = append(args, "-overlay="+overlayFile)
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("go", append(args, ".")...)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("go list %s: %w: %s", dir, err, strings.TrimSpace(stderr.String()))
+	}
+
+	base := filepath.Base(filename)
+	decoder := json.NewDecoder(stdout)
+	for decoder.More() {
+		pkg := &listedPackage{}
+		if err := decoder.Decode(pkg); err != nil {
+			return nil, fmt.Errorf("go list %s: %w", dir, err)
+		}
+		// the package itself comes before its test variant
+		importPath, _, _ := strings.Cut(pkg.ImportPath, " ")
+
+		switch {
+		case pkg.Error == nil && contains(pkg.GoFiles, base):
+			return &packageInfo{
+				importPath:   importPath,
+				name:         pkg.Name,
+				externalTest: len(pkg.ForTest) > 0 && importPath != pkg.ForTest,
+			}, nil
+		case len(importPath) > 0 && contains(pkg.IgnoredGoFiles, base):
+			// the package has an error when all its files are excluded
+			result := &packageInfo{
+				importPath:   importPath,
+				name:         packageName,
+				externalTest: strings.HasSuffix(packageName, "_test") && packageName != pkg.Name,
+			}
+			if result.externalTest {
+				result.importPath += "_test"
+			}
+			return result, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// listable reports whether go list sees the files of fsys: the disk, or an
+// api.Overlay over the disk, given to go list as an overlay.
+func listable(fsys api.FS) bool {
+	if overlay, ok := fsys.(*api.Overlay); ok {
+		return overlay.Base == api.OS
+	}
+	return fsys == api.OS
+}
+
+// memoryPackage returns the package of the file of fileInfo for a file go list
+// does not see, like one of an api.Overlay without a Base or in GOPATH mode:
+// the package of its directory in its module, or the external test package of
+// the directory when packageName, the one of its package clause, ends with
+// _test.
+func memoryPackage(fileInfo *api.FileInfo, packageName string) (*packageInfo, error) {
+	rel, err := filepath.Rel(fileInfo.ModuleAbsLocalPath, filepath.Dir(fileInfo.FileFullAbsLocalPath))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &packageInfo{
+		importPath:   path.Join(fileInfo.ModuleName, filepath.ToSlash(rel)),
+		name:         packageName,
+		externalTest: strings.HasSuffix(packageName, "_test"),
+	}
+	if result.externalTest {
+		result.importPath += "_test"
+	}
+	return result, nil
+}
+
+// writeListOverlay writes the overlay file of go list for the files of
+// overlay, and returns its path, in a temporary directory.
+func writeListOverlay(overlay *api.Overlay) (string, error) {
+	dir, err := os.MkdirTemp("", "ag-overlay")
+	if err != nil {
+		return "", err
+	}
+
+	replace := map[string]string{}
+	i := 0
+	for name, content := range overlay.Files {
+		file := filepath.Join(dir, fmt.Sprintf("%d%s", i, filepath.Ext(name)))
+		if err = os.WriteFile(file, content, 0o644); err != nil {
+			return "", errors.Join(err, os.RemoveAll(dir))
+		}
+		replace[name] = file
+		i++
+	}
+
+	data, err := json.Marshal(map[string]any{"Replace": replace})
+	if err != nil {
+		return "", errors.Join(err, os.RemoveAll(dir))
+	}
+	overlayFile := filepath.Join(dir, "overlay.json")
+	if err = os.WriteFile(overlayFile, data, 0o644); err != nil {
+		return "", errors.Join(err, os.RemoveAll(dir))
+	}
+	return overlayFile, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
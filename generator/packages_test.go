@@ -0,0 +1,86 @@
+package generator
+
+import (
+	"github.com/expgo/ag/api"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPackage(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a.go":               "package x\n",
+		"a_test.go":          "package x\n",
+		"b_test.go":          "package x_test\n",
+		"sub/c.go":           "package c\n",
+		"e.go":               "//go:build integration\n\npackage x\n",
+		"f_windows.go":       "package x\n",
+		"f_windows_test.go":  "package x_test\n",
+		"windows/g_plan9.go": "package windows\n",
+		"_h.go":              "package x\n",
+	})
+
+	for _, tt := range []struct {
+		file        string
+		packageName string
+		expected    packageInfo
+	}{
+		{"a.go", "x", packageInfo{importPath: "example.com/x", name: "x"}},
+		{"a_test.go", "x", packageInfo{importPath: "example.com/x", name: "x"}},
+		{"b_test.go", "x_test", packageInfo{importPath: "example.com/x_test", name: "x_test", externalTest: true}},
+		{"sub/c.go", "c", packageInfo{importPath: "example.com/x/sub", name: "c"}},
+		// excluded by build constraints
+		{"e.go", "x", packageInfo{importPath: "example.com/x", name: "x"}},
+		{"f_windows.go", "x", packageInfo{importPath: "example.com/x", name: "x"}},
+		{"f_windows_test.go", "x_test", packageInfo{importPath: "example.com/x_test", name: "x_test", externalTest: true}},
+		{"windows/g_plan9.go", "windows", packageInfo{importPath: "example.com/x/windows", name: "windows"}},
+	} {
+		pkg, err := loadPackage(api.OS, filepath.Join(dir, tt.file), tt.packageName)
+		if assert.NoError(t, err, tt.file) {
+			assert.Equal(t, tt.expected, *pkg, tt.file)
+		}
+	}
+
+	// an unsaved file
+	overlay := api.NewOverlay(api.OS, map[string][]byte{filepath.Join(dir, "d_test.go"): []byte("package x_test\n")})
+	pkg, err := loadPackage(overlay, filepath.Join(dir, "d_test.go"), "x_test")
+	if assert.NoError(t, err) {
+		assert.Equal(t, packageInfo{importPath: "example.com/x_test", name: "x_test", externalTest: true}, *pkg)
+	}
+
+	// a file go list ignores
+	pkg, err = loadPackage(api.OS, filepath.Join(dir, "_h.go"), "x")
+	if assert.NoError(t, err) {
+		assert.Nil(t, pkg)
+	}
+}
+
+func TestMemoryPackage(t *testing.T) {
+	memory := api.NewOverlay(nil, map[string][]byte{
+		"/virtual/x/go.mod":        []byte("module example.com/x\n"),
+		"/virtual/x/sub/a_test.go": []byte("package sub_test\n"),
+		"/virtual/x/sub/b_test.go": []byte("package sub\n"),
+	})
+	assert.False(t, listable(memory))
+	assert.False(t, listable(api.NewOverlay(memory, nil)))
+	assert.True(t, listable(api.NewOverlay(api.OS, nil)))
+	assert.True(t, listable(api.OS))
+
+	for _, tt := range []struct {
+		file        string
+		packageName string
+		expected    packageInfo
+	}{
+		{"/virtual/x/sub/a_test.go", "sub_test", packageInfo{importPath: "example.com/x/sub_test", name: "sub_test", externalTest: true}},
+		{"/virtual/x/sub/b_test.go", "sub", packageInfo{importPath: "example.com/x/sub", name: "sub"}},
+	} {
+		fi, err := api.GetFileInfoFS(memory, tt.file)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		pkg, err := memoryPackage(fi, tt.packageName)
+		if assert.NoError(t, err, tt.file) {
+			assert.Equal(t, tt.expected, *pkg, tt.file)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package generator
+
+import (
+	"fmt"
+	"github.com/expgo/ag/api"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// claim is an annotation name claimed by a factory, for some annotation types.
+// index is the position of the factory in the factories of the registry, which
+// identifies it as factories may not be comparable.
+type claim struct {
+	factory   api.GeneratorFactory
+	index     int
+	namespace string
+	name      string
+	types     []api.AnnotationType
+}
+
+func (c *claim) qualifiedName() string {
+	return c.namespace + "." + c.name
+}
+
+func (c *claim) hasType(t api.AnnotationType) bool {
+	for _, ct := range c.types {
+		if ct == t {
+			return true
+		}
+	}
+	return false
+}
+
+// registry resolves the annotation names found in the sources to the factory
+// claiming them. A name is either qualified by a namespace, like enum.Enum,
+// or short, like Enum, and then looked up in the aliases of the module first.
+type registry struct {
+	claims  map[string][]*claim // short name -> claims
+	aliases map[string]string
+}
+
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// factoryNamespace returns the namespace of f, which is the last element of
+// its package path unless f implements api.Namespaced.
+func factoryNamespace(f api.GeneratorFactory) string {
+	if n, ok := f.(api.Namespaced); ok {
+		return n.Namespace()
+	}
+
+	t := reflect.TypeOf(f)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	pkgPath := t.PkgPath()
+	if majorVersion.MatchString(path.Base(pkgPath)) {
+		pkgPath = path.Dir(pkgPath)
+	}
+	return path.Base(pkgPath)
+}
+
+// newRegistry registers the annotations claimed by factories. Two factories
+// claiming the same name for the same annotation type conflict, unless they
+// are in different namespaces and aliases picks one of them for the short
+// name.
+func newRegistry(factories []api.GeneratorFactory, aliases map[string]string) (*registry, error) {
+	r := &registry{claims: map[string][]*claim{}, aliases: aliases}
+
+	for i, f := range factories {
+		namespace := factoryNamespace(f)
+		for name, types := range f.Annotations() {
+			r.claims[name] = append(r.claims[name], &claim{factory: f, index: i, namespace: namespace, name: name, types: types})
+		}
+	}
+
+	var errs []string
+	for name, claims := range r.claims {
+		for i, x := range claims {
+			for _, y := range claims[i+1:] {
+				if !overlaps(x, y) {
+					continue
+				}
+
+				switch {
+				case x.namespace == y.namespace:
+					errs = append(errs, fmt.Sprintf("annotation @%s is claimed by both %s and %s, in the same namespace %q",
+						x.qualifiedName(), factoryPath(x.factory), factoryPath(y.factory), x.namespace))
+				case len(aliases[name]) == 0:
+					errs = append(errs, fmt.Sprintf("annotation @%s is claimed by both %s and %s, write @%s or @%s, or add an alias for %s in %s",
+						name, factoryPath(x.factory), factoryPath(y.factory), x.qualifiedName(), y.qualifiedName(), name, api.ConfigFileName))
+				}
+			}
+		}
+	}
+
+	for alias, target := range aliases {
+		namespace, _ := api.SplitName(target)
+		if len(namespace) == 0 || r.lookup(target) == nil {
+			errs = append(errs, fmt.Sprintf("alias %s of %s: no plugin claims @%s", alias, api.ConfigFileName, target))
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+
+	return r, nil
+}
+
+func overlaps(x, y *claim) bool {
+	for _, t := range x.types {
+		if y.hasType(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func factoryPath(f api.GeneratorFactory) string {
+	return pkgPath(f)
+}
+
+// pkgPath returns the package path of the type of v, or of the type v points
+// to.
+func pkgPath(v any) string {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath()
+}
+
+// lookup returns the claims of a name, following aliases and namespaces.
+func (r *registry) lookup(name string) []*claim {
+	if target, ok := r.aliases[name]; ok {
+		name = target
+	}
+
+	namespace, short := api.SplitName(name)
+	if len(namespace) == 0 {
+		return r.claims[short]
+	}
+
+	for _, c := range r.claims[short] {
+		if c.namespace == namespace {
+			return []*claim{c}
+		}
+	}
+	return nil
+}
+
+// resolve returns the claim of the annotation named name on a node of type t.
+func (r *registry) resolve(name string, t api.AnnotationType) *claim {
+	for _, c := range r.lookup(name) {
+		if c.hasType(t) {
+			return c
+		}
+	}
+	return nil
+}
+
+// typeMaps returns every name an annotation can be written with, by
+// annotation type: the short name, the qualified one and the aliases.
+func (r *registry) typeMaps() map[api.AnnotationType][]string {
+	result := map[api.AnnotationType][]string{}
+
+	add := func(c *claim, name string) {
+		for _, t := range c.types {
+			result[t] = append(result[t], name)
+		}
+	}
+
+	for _, claims := range r.claims {
+		for _, c := range claims {
+			add(c, c.name)
+			add(c, c.qualifiedName())
+		}
+	}
+
+	for alias, target := range r.aliases {
+		for _, c := range r.lookup(target) {
+			add(c, alias)
+		}
+	}
+
+	return result
+}
+
+// filterTypedAnnotation returns the typed annotations with an annotation
+// claimed by the factory at index in the factories of the registry, as it
+// sees them: the annotations claimed by another factory are left out, and the
+// ones claimed by the factory are renamed to the short name it claimed them
+// with.
+func (r *registry) filterTypedAnnotation(typedAnnotations []*api.TypedAnnotation, index int) []*api.TypedAnnotation {
+	views := map[*api.TypedAnnotation]*api.TypedAnnotation{}
+	claimed := map[*api.TypedAnnotation]bool{}
+
+	var view func(ta *api.TypedAnnotation) *api.TypedAnnotation
+	view = func(ta *api.TypedAnnotation) *api.TypedAnnotation {
+		if ta == nil {
+			return nil
+		}
+		if v, ok := views[ta]; ok {
+			return v
+		}
+
+		v := *ta
+		views[ta] = &v
+		v.Parent = view(ta.Parent)
+
+		if ta.Annotations != nil {
+			v.Annotations = &api.Annotations{}
+			for _, an := range ta.Annotations.Annotations {
+				c := r.resolve(an.Name, ta.Type)
+				switch {
+				case c == nil:
+					v.Annotations.Annotations = append(v.Annotations.Annotations, an)
+				case c.index == index:
+					renamed := *an
+					renamed.Name = c.name
+					v.Annotations.Annotations = append(v.Annotations.Annotations, &renamed)
+					claimed[ta] = true
+				}
+			}
+		}
+
+		return &v
+	}
+
+	result := []*api.TypedAnnotation{}
+	for _, ta := range typedAnnotations {
+		if v := view(ta); claimed[ta] {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,92 @@
+package generator
+
+import (
+	"github.com/expgo/ag/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testFactory struct {
+	namespace   string
+	annotations map[string][]api.AnnotationType
+}
+
+func (f *testFactory) Namespace() string { return f.namespace }
+
+func (f *testFactory) Annotations() map[string][]api.AnnotationType { return f.annotations }
+
+func (f *testFactory) New([]*api.TypedAnnotation) (api.Generator, error) { return nil, nil }
+
+// testValueFactory is a factory used by value, which is not comparable.
+type testValueFactory struct {
+	names []string
+}
+
+func (f testValueFactory) Annotations() map[string][]api.AnnotationType {
+	result := map[string][]api.AnnotationType{}
+	for _, name := range f.names {
+		result[name] = []api.AnnotationType{api.AnnotationTypeType}
+	}
+	return result
+}
+
+func (f testValueFactory) New([]*api.TypedAnnotation) (api.Generator, error) { return nil, nil }
+
+func TestRegistry(t *testing.T) {
+	enum := &testFactory{"enum", map[string][]api.AnnotationType{"Enum": {api.AnnotationTypeType}}}
+	myco := &testFactory{"myco", map[string][]api.AnnotationType{"Enum": {api.AnnotationTypeType}, "Route": {api.AnnotationTypeFunc}}}
+	factories := []api.GeneratorFactory{enum, myco}
+
+	_, err := newRegistry(factories, nil)
+	assert.EqualError(t, err, "annotation @Enum is claimed by both "+
+		"github.com/expgo/ag/generator and github.com/expgo/ag/generator, "+
+		"write @enum.Enum or @myco.Enum, or add an alias for Enum in ag.yaml")
+
+	_, err = newRegistry(factories, map[string]string{"Enum": "enum.Enum", "Bad": "other.Enum"})
+	assert.EqualError(t, err, "alias Bad of ag.yaml: no plugin claims @other.Enum")
+
+	reg, err := newRegistry(factories, map[string]string{"Enum": "enum.Enum"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ta := &api.TypedAnnotation{
+		Type: api.AnnotationTypeType,
+		Annotations: &api.Annotations{Annotations: []*api.Annotation{
+			{Name: "Enum"},
+			{Name: "myco.Enum"},
+			{Name: "enum"},
+			{Name: "Other"},
+		}},
+	}
+
+	names := func(tas []*api.TypedAnnotation) (result []string) {
+		for _, ta := range tas {
+			for _, an := range ta.Annotations.Annotations {
+				result = append(result, an.Name)
+			}
+		}
+		return
+	}
+
+	assert.Equal(t, []string{"Enum", "enum", "Other"}, names(reg.filterTypedAnnotation([]*api.TypedAnnotation{ta}, 0)))
+	assert.Equal(t, []string{"Enum", "enum", "Other"}, names(reg.filterTypedAnnotation([]*api.TypedAnnotation{ta}, 1)))
+	assert.Equal(t, "myco.Enum", ta.Annotations.Annotations[1].Name)
+
+	other := &api.TypedAnnotation{Type: api.AnnotationTypeType, Annotations: &api.Annotations{Annotations: []*api.Annotation{{Name: "Other"}}}}
+	assert.Empty(t, reg.filterTypedAnnotation([]*api.TypedAnnotation{other}, 0))
+
+	assert.ElementsMatch(t, []string{"Enum", "enum.Enum", "Enum", "myco.Enum", "Enum"}, reg.typeMaps()[api.AnnotationTypeType])
+}
+
+func TestRegistryValueFactories(t *testing.T) {
+	factories := []api.GeneratorFactory{testValueFactory{names: []string{"A"}}, testValueFactory{names: []string{"B"}}}
+	reg, err := newRegistry(factories, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ta := &api.TypedAnnotation{Type: api.AnnotationTypeType, Annotations: &api.Annotations{Annotations: []*api.Annotation{{Name: "B"}}}}
+	assert.Empty(t, reg.filterTypedAnnotation([]*api.TypedAnnotation{ta}, 0))
+	assert.Len(t, reg.filterTypedAnnotation([]*api.TypedAnnotation{ta}, 1), 1)
+}
@@ -10,9 +10,11 @@ require (
 	github.com/expgo/log v0.0.0-20240517023735-199bf09720ed
 	github.com/expgo/structure v0.0.0-20240515010801-898cf0e94ad3
 	github.com/google/go-cmp v0.6.0
+	github.com/iancoleman/strcase v0.3.0
 	github.com/stretchr/testify v1.9.0
 	golang.org/x/mod v0.20.0
 	golang.org/x/tools v0.19.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -20,9 +22,7 @@ require (
 	github.com/expgo/generic v0.0.0-20240814064603-ecd54aed10dc // indirect
 	github.com/expgo/sync v0.0.0-20240603064429-fb40bfd6db49 // indirect
 	github.com/expr-lang/expr v1.16.9 // indirect
-	github.com/iancoleman/strcase v0.3.0 // indirect
 	github.com/petermattis/goid v0.0.0-20240813172612-4fcff4a6cae7 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/sasha-s/go-deadlock v0.3.5 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )